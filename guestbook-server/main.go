@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	server "github.com/bryankaraffa/b10a.co/guestbook-server/pkg"
+	"github.com/bryankaraffa/b10a.co/guestbook-server/pkg/fakeapi"
 	"github.com/joho/godotenv"
 )
 
@@ -25,6 +32,17 @@ func maskKey(key string) string {
 	return key[:4] + "..." + key[len(key)-4:]
 }
 
+// splitList parses a comma-separated environment variable, ignoring blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func main() {
 	// Check if debug mode is enabled
 	if os.Getenv("DEBUG") == "true" {
@@ -43,21 +61,68 @@ func main() {
 		debugLog("Loaded .env.local file")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "train" {
+		if err := train(os.Args[2:]); err != nil {
+			log.Fatal("Failed to train Bayes model: ", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fakeapi" {
+		if err := fakeAPI(os.Args[2:]); err != nil {
+			log.Fatal("Failed to run fake APIs: ", err)
+		}
+		return
+	}
+
 	// Load configuration from environment variables
 	config := &server.Config{
 		Port:                   os.Getenv("PORT"),
 		AkismetAPIKey:          os.Getenv("AKISMET_API_KEY"),
 		AkismetSiteURL:         os.Getenv("AKISMET_SITE_URL"),
+		AkismetBlogLang:        os.Getenv("AKISMET_BLOG_LANG"),
+		AkismetTestMode:        os.Getenv("AKISMET_TEST_MODE") == "true",
+		AkismetRequireValidKey: os.Getenv("AKISMET_REQUIRE_VALID_KEY") == "true",
+		AkismetEndpoint:        os.Getenv("AKISMET_ENDPOINT"),
 		RecaptchaSecretKey:     os.Getenv("RECAPTCHA_SECRET_KEY"),
+		CaptchaProvider:        os.Getenv("CAPTCHA_PROVIDER"),
+		CaptchaSecretKey:       os.Getenv("CAPTCHA_SECRET_KEY"),
+		CaptchaVerifyURL:       os.Getenv("CAPTCHA_VERIFY_URL"),
+		CaptchaField:           os.Getenv("CAPTCHA_FIELD"),
+		CaptchaHostnames:       splitList(os.Getenv("CAPTCHA_HOSTNAMES")),
+		RecaptchaVersion:       os.Getenv("RECAPTCHA_VERSION"),
+		RecaptchaActions:       splitList(os.Getenv("RECAPTCHA_ACTIONS")),
+		ProofOfWorkSecret:      os.Getenv("POW_SECRET"),
+		FormTokens:             os.Getenv("FORM_TOKENS") == "true",
+		FormTokenSecret:        os.Getenv("FORM_TOKEN_SECRET"),
 		GitHubToken:            os.Getenv("GITHUB_TOKEN"),
 		GitHubOwner:            os.Getenv("GITHUB_OWNER"),
 		GitHubRepo:             os.Getenv("GITHUB_REPO"),
 		GitHubBranch:           os.Getenv("GITHUB_BRANCH"),
+		GitHubPublishMode:      os.Getenv("GITHUB_PUBLISH_MODE"),
+		GitHubPendingBranch:    os.Getenv("GITHUB_PENDING_BRANCH"),
+		StorageBackend:         os.Getenv("STORAGE_BACKEND"),
+		FileStorePath:          os.Getenv("FILE_STORE_PATH"),
+		DatabasePath:           os.Getenv("DATABASE_PATH"),
+		AdminToken:             os.Getenv("ADMIN_TOKEN"),
+		SpamChecks:             splitList(os.Getenv("SPAM_CHECKS")),
+		ShadowSpamChecks:       splitList(os.Getenv("SHADOW_SPAM_CHECKS")),
+		SpamRulesPath:          os.Getenv("SPAM_RULES_PATH"),
+		BayesModelPath:         os.Getenv("BAYES_MODEL_PATH"),
+		DomainBlocklistPath:    os.Getenv("DOMAIN_BLOCKLIST_PATH"),
+		IPAllowlist:            splitList(os.Getenv("IP_ALLOWLIST")),
+		IPAllowlistPath:        os.Getenv("IP_ALLOWLIST_PATH"),
+		IPBlocklist:            splitList(os.Getenv("IP_BLOCKLIST")),
+		IPBlocklistPath:        os.Getenv("IP_BLOCKLIST_PATH"),
 		AllowedOrigins:         []string{"https://b10a.co", "http://localhost:1313"},
 		AllowedRedirectDomains: []string{"b10a.co", "localhost"},
 		RedirectURL:            os.Getenv("REDIRECT_URL"),
 		RateLimitRequests:      10, // 10 requests per minute
 		RateLimitWindow:        60, // 60 seconds
+		RateLimitBackend:       os.Getenv("RATE_LIMIT_BACKEND"),
+		RedisURL:               os.Getenv("REDIS_URL"),
+		TrustedProxies:         splitList(os.Getenv("TRUSTED_PROXIES")),
+		RemoteIPHeaders:        splitList(os.Getenv("REMOTE_IP_HEADERS")),
+		TrustedPlatform:        os.Getenv("TRUSTED_PLATFORM"),
 	}
 
 	// Parse RecaptchaScoreThreshold from environment variable
@@ -74,6 +139,131 @@ func main() {
 		debugLog("Using default reCAPTCHA score threshold: 0.5")
 	}
 
+	// Parse per-check failure policies, e.g. AKISMET_FAILURE_POLICY=review
+	config.FailurePolicies = map[string]server.FailurePolicy{}
+	for check, envVar := range map[string]string{
+		"recaptcha": "RECAPTCHA_FAILURE_POLICY",
+		"akismet":   "AKISMET_FAILURE_POLICY",
+	} {
+		value := os.Getenv(envVar)
+		if value == "" {
+			continue
+		}
+		policy, err := server.ParseFailurePolicy(value)
+		if err != nil {
+			log.Printf("Invalid %s value: %v, using default", envVar, err)
+			continue
+		}
+		config.FailurePolicies[check] = policy
+	}
+
+	// Parse spam scoring, e.g. SPAM_WEIGHTS=keyword=0.3,links=0.8
+	if weights := splitList(os.Getenv("SPAM_WEIGHTS")); len(weights) > 0 {
+		config.SpamWeights = map[string]float64{}
+		for _, item := range weights {
+			signal, value, _ := strings.Cut(item, "=")
+			weight, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				log.Printf("Invalid SPAM_WEIGHTS entry: %s, ignoring", item)
+				continue
+			}
+			config.SpamWeights[strings.TrimSpace(signal)] = weight
+		}
+	}
+	for envVar, threshold := range map[string]*float64{
+		"SPAM_ACCEPT_THRESHOLD": &config.SpamAcceptThreshold,
+		"SPAM_REJECT_THRESHOLD": &config.SpamRejectThreshold,
+	} {
+		if value := os.Getenv(envVar); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				log.Printf("Invalid %s value: %s, using default", envVar, value)
+				continue
+			}
+			*threshold = parsed
+		}
+	}
+
+	if intervalStr := os.Getenv("SPAM_RULES_RELOAD_INTERVAL"); intervalStr != "" {
+		if interval, err := strconv.Atoi(intervalStr); err == nil {
+			config.SpamRulesReloadInterval = interval
+		} else {
+			log.Printf("Invalid SPAM_RULES_RELOAD_INTERVAL value: %s, using default 30", intervalStr)
+		}
+	}
+
+	if ageStr := os.Getenv("CAPTCHA_MAX_TOKEN_AGE"); ageStr != "" {
+		if age, err := strconv.Atoi(ageStr); err == nil {
+			config.CaptchaMaxTokenAge = age
+		} else {
+			log.Printf("Invalid CAPTCHA_MAX_TOKEN_AGE value: %s, using default 120", ageStr)
+		}
+	}
+
+	if difficultyStr := os.Getenv("POW_DIFFICULTY"); difficultyStr != "" {
+		if difficulty, err := strconv.Atoi(difficultyStr); err == nil {
+			config.ProofOfWorkDifficulty = difficulty
+		} else {
+			log.Printf("Invalid POW_DIFFICULTY value: %s, using default 16", difficultyStr)
+		}
+	}
+
+	for envVar, setting := range map[string]*int{
+		"RATE_LIMIT_REQUESTS":      &config.RateLimitRequests,
+		"RATE_LIMIT_WINDOW":        &config.RateLimitWindow,
+		"RATE_LIMIT_IPV4_PREFIX":   &config.RateLimitIPv4Prefix,
+		"RATE_LIMIT_IPV6_PREFIX":   &config.RateLimitIPv6Prefix,
+		"RATE_LIMIT_GLOBAL_HOURLY": &config.RateLimitGlobalHourly,
+		"RATE_LIMIT_MAX_CLIENTS":   &config.RateLimitMaxClients,
+	} {
+		if value := os.Getenv(envVar); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				log.Printf("Invalid %s value: %s, using default", envVar, value)
+				continue
+			}
+			*setting = parsed
+		}
+	}
+
+	// Parse per-route rate limits, e.g. RATE_LIMIT_POLICIES=POST /guestbook=5/600,GET /challenge=off
+	if policies := splitList(os.Getenv("RATE_LIMIT_POLICIES")); len(policies) > 0 {
+		config.RateLimitPolicies = map[string]server.RateLimitPolicy{}
+		for _, item := range policies {
+			route, value, _ := strings.Cut(item, "=")
+			policy, err := server.ParseRateLimitPolicy(value)
+			if err != nil {
+				log.Printf("Invalid RATE_LIMIT_POLICIES entry: %s, ignoring: %v", item, err)
+				continue
+			}
+			config.RateLimitPolicies[strings.TrimSpace(route)] = policy
+		}
+	}
+
+	if ageStr := os.Getenv("FORM_TOKEN_MIN_AGE"); ageStr != "" {
+		if age, err := strconv.Atoi(ageStr); err == nil {
+			config.FormTokenMinAge = age
+		} else {
+			log.Printf("Invalid FORM_TOKEN_MIN_AGE value: %s, using default 3", ageStr)
+		}
+	}
+
+	if ageStr := os.Getenv("FORM_TOKEN_MAX_AGE"); ageStr != "" {
+		if age, err := strconv.Atoi(ageStr); err == nil {
+			config.FormTokenMaxAge = age
+		} else {
+			log.Printf("Invalid FORM_TOKEN_MAX_AGE value: %s, using default 7200", ageStr)
+		}
+	}
+
+	if windowStr := os.Getenv("DUPLICATE_WINDOW"); windowStr != "" {
+		if window, err := strconv.Atoi(windowStr); err == nil {
+			config.DuplicateWindow = window
+		} else {
+			log.Printf("Invalid DUPLICATE_WINDOW value: %s, using default 3600", windowStr)
+		}
+	}
+
 	// Set defaults
 	if config.Port == "" {
 		config.Port = "8080"
@@ -81,6 +271,9 @@ func main() {
 	if config.AkismetSiteURL == "" {
 		config.AkismetSiteURL = "https://b10a.co"
 	}
+	if config.AkismetBlogLang == "" {
+		config.AkismetBlogLang = "en"
+	}
 	if config.GitHubOwner == "" {
 		config.GitHubOwner = "bryankaraffa"
 	}
@@ -90,6 +283,9 @@ func main() {
 	if config.GitHubBranch == "" {
 		config.GitHubBranch = "main"
 	}
+	if config.StorageBackend == "" {
+		config.StorageBackend = "github"
+	}
 	if config.RedirectURL == "" {
 		config.RedirectURL = "https://b10a.co/guestbook-success?success=true"
 	}
@@ -99,14 +295,63 @@ func main() {
 	debugLog("  Port: %s", config.Port)
 	debugLog("  AkismetAPIKey: %s", maskKey(config.AkismetAPIKey))
 	debugLog("  AkismetSiteURL: %s", config.AkismetSiteURL)
+	debugLog("  AkismetBlogLang: %s", config.AkismetBlogLang)
+	debugLog("  AkismetTestMode: %t", config.AkismetTestMode)
+	debugLog("  AkismetRequireValidKey: %t", config.AkismetRequireValidKey)
+	debugLog("  AkismetEndpoint: %s", config.AkismetEndpoint)
 	debugLog("  RecaptchaSecretKey: %s", maskKey(config.RecaptchaSecretKey))
 	debugLog("  RecaptchaScoreThreshold: %.2f", config.RecaptchaScoreThreshold)
+	debugLog("  CaptchaProvider: %s", config.CaptchaProvider)
+	debugLog("  CaptchaSecretKey: %s", maskKey(config.CaptchaSecretKey))
+	debugLog("  CaptchaVerifyURL: %s", config.CaptchaVerifyURL)
+	debugLog("  CaptchaField: %s", config.CaptchaField)
+	debugLog("  CaptchaHostnames: %v", config.CaptchaHostnames)
+	debugLog("  CaptchaMaxTokenAge: %d", config.CaptchaMaxTokenAge)
+	debugLog("  RecaptchaVersion: %s", config.RecaptchaVersion)
+	debugLog("  RecaptchaActions: %v", config.RecaptchaActions)
+	debugLog("  ProofOfWorkSecret: %s", maskKey(config.ProofOfWorkSecret))
+	debugLog("  ProofOfWorkDifficulty: %d", config.ProofOfWorkDifficulty)
+	debugLog("  FormTokens: %t", config.FormTokens)
+	debugLog("  FormTokenSecret: %s", maskKey(config.FormTokenSecret))
+	debugLog("  FormTokenMinAge: %d", config.FormTokenMinAge)
+	debugLog("  FormTokenMaxAge: %d", config.FormTokenMaxAge)
+	debugLog("  FailurePolicies: %v", config.FailurePolicies)
+	debugLog("  SpamChecks: %v", config.SpamChecks)
+	debugLog("  ShadowSpamChecks: %v", config.ShadowSpamChecks)
+	debugLog("  SpamWeights: %v", config.SpamWeights)
+	debugLog("  SpamAcceptThreshold: %.2f", config.SpamAcceptThreshold)
+	debugLog("  SpamRejectThreshold: %.2f", config.SpamRejectThreshold)
+	debugLog("  SpamRulesPath: %s", config.SpamRulesPath)
+	debugLog("  SpamRulesReloadInterval: %d", config.SpamRulesReloadInterval)
+	debugLog("  BayesModelPath: %s", config.BayesModelPath)
+	debugLog("  DuplicateWindow: %d", config.DuplicateWindow)
+	debugLog("  DomainBlocklistPath: %s", config.DomainBlocklistPath)
+	debugLog("  IPAllowlist: %v", config.IPAllowlist)
+	debugLog("  IPAllowlistPath: %s", config.IPAllowlistPath)
+	debugLog("  IPBlocklist: %v", config.IPBlocklist)
+	debugLog("  IPBlocklistPath: %s", config.IPBlocklistPath)
 	debugLog("  GitHubToken: %s", maskKey(config.GitHubToken))
 	debugLog("  GitHubOwner: %s", config.GitHubOwner)
 	debugLog("  GitHubRepo: %s", config.GitHubRepo)
+	debugLog("  GitHubPublishMode: %s", config.GitHubPublishMode)
+	debugLog("  GitHubPendingBranch: %s", config.GitHubPendingBranch)
+	debugLog("  StorageBackend: %s", config.StorageBackend)
+	debugLog("  FileStorePath: %s", config.FileStorePath)
+	debugLog("  DatabasePath: %s", config.DatabasePath)
+	debugLog("  AdminToken: %s", maskKey(config.AdminToken))
 	debugLog("  RedirectURL: %s", config.RedirectURL)
 	debugLog("  RateLimitRequests: %d", config.RateLimitRequests)
 	debugLog("  RateLimitWindow: %d", config.RateLimitWindow)
+	debugLog("  RateLimitPolicies: %v", config.RateLimitPolicies)
+	debugLog("  RateLimitIPv4Prefix: %d", config.RateLimitIPv4Prefix)
+	debugLog("  RateLimitIPv6Prefix: %d", config.RateLimitIPv6Prefix)
+	debugLog("  RateLimitGlobalHourly: %d", config.RateLimitGlobalHourly)
+	debugLog("  RateLimitMaxClients: %d", config.RateLimitMaxClients)
+	debugLog("  RateLimitBackend: %s", config.RateLimitBackend)
+	debugLog("  RedisURL: %s", maskKey(config.RedisURL))
+	debugLog("  TrustedProxies: %v", config.TrustedProxies)
+	debugLog("  RemoteIPHeaders: %v", config.RemoteIPHeaders)
+	debugLog("  TrustedPlatform: %s", config.TrustedPlatform)
 
 	// Create and start server
 	srv := server.New(config)
@@ -115,3 +360,79 @@ func main() {
 		log.Fatal("Failed to start server:", err)
 	}
 }
+
+// train implements the "train" subcommand, which rebuilds the Bayes spam
+// classifier from published entries and the submission database
+func train(args []string) error {
+	dataDir := "../data/guestbook"
+	if root := os.Getenv("FILE_STORE_PATH"); root != "" {
+		dataDir = filepath.Join(root, "data", "guestbook")
+	}
+	modelPath := os.Getenv("BAYES_MODEL_PATH")
+	if modelPath == "" {
+		modelPath = "bayes-model.json"
+	}
+
+	flags := flag.NewFlagSet("train", flag.ContinueOnError)
+	flags.StringVar(&dataDir, "data", dataDir, "directory of published entry YAML files, used as ham (empty to skip)")
+	dbPath := flags.String("db", os.Getenv("DATABASE_PATH"), "submission database with approved and spam entries (empty to skip)")
+	flags.StringVar(&modelPath, "out", modelPath, "where to write the model")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	var records server.EntryStore
+	if *dbPath != "" {
+		store, err := server.NewSQLiteStore(*dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		records = store
+	}
+
+	model, err := server.TrainBayesModel(context.Background(), dataDir, records)
+	if err != nil {
+		return err
+	}
+	if !model.Trained() {
+		log.Printf("Warning: the model needs both ham and spam examples to classify anything")
+	}
+	if err := model.Save(modelPath); err != nil {
+		return err
+	}
+	log.Printf("Trained Bayes model on %d spam and %d ham entries, saved to %s", model.SpamDocs, model.HamDocs, modelPath)
+	return nil
+}
+
+// fakeAPI implements the "fakeapi" subcommand, which serves stand-ins for
+// Akismet and the captcha siteverify endpoints for local development
+func fakeAPI(args []string) error {
+	flags := flag.NewFlagSet("fakeapi", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8089", "address to listen on")
+	hostname := flags.String("hostname", "localhost", "hostname captcha tokens are reported as minted for")
+	rejectCaptcha := flags.Bool("reject-captcha", false, "fail captcha tokens other than \"pass\" instead of accepting them")
+	spam := flags.String("spam", "", "comma-separated phrases Akismet flags as spam")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	fake := fakeapi.New(*hostname)
+	if *rejectCaptcha {
+		fake.SetDefaultCaptcha(fakeapi.Fail())
+	}
+	for _, phrase := range splitList(*spam) {
+		fake.SetAkismet(phrase, fakeapi.AkismetVerdict{Spam: true})
+	}
+
+	log.Printf("Serving fake Akismet and captcha APIs on %s", *addr)
+	log.Printf("  AKISMET_ENDPOINT=http://%s/1.1", *addr)
+	log.Printf("  CAPTCHA_VERIFY_URL=http://%s/siteverify", *addr)
+	return http.ListenAndServe(*addr, fake)
+}
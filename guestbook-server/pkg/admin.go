@@ -0,0 +1,346 @@
+package guestbook_server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errNoStore = errors.New("no storage backend configured")
+	// errNotEditable is returned when a moderator edits an entry that is no longer pending
+	errNotEditable = errors.New("only pending entries can be edited")
+)
+
+type adminDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type adminEditRequest struct {
+	Name    *string `json:"name"`
+	Message *string `json:"message"`
+}
+
+// setupAdminRoutes registers the moderation API. It is only available when an
+// admin token is configured.
+func (s *Server) setupAdminRoutes() {
+	if s.config.AdminToken == "" {
+		return
+	}
+
+	admin := s.router.Group("/admin", s.adminAuthMiddleware)
+	admin.GET("/entries", s.handleAdminListEntries)
+	admin.GET("/entries/:id", s.handleAdminGetEntry)
+	admin.PATCH("/entries/:id", s.handleAdminEditEntry)
+	admin.POST("/entries/:id/approve", s.handleAdminDecision(StatusApproved))
+	admin.POST("/entries/:id/reject", s.handleAdminDecision(StatusRejected))
+	admin.POST("/entries/:id/spam", s.handleAdminDecision(StatusSpam))
+
+	// Server-rendered dashboard for moderating from a browser
+	admin.GET("/", s.handleDashboard)
+	admin.POST("/moderate", s.handleDashboardModerate)
+}
+
+// adminAuthMiddleware accepts the admin token either as a bearer token, for
+// API clients, or as the HTTP basic auth password so the dashboard can be
+// opened in a browser
+func (s *Server) adminAuthMiddleware(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = c.Request.BasicAuth()
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+		serverDebugLog("Rejected admin request from IP: %s", c.ClientIP())
+		c.Header("WWW-Authenticate", `Basic realm="Guestbook moderation"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+// moderationEnabled reports whether accepted submissions wait in the
+// submission database for a moderator instead of being published right away
+func (s *Server) moderationEnabled() bool {
+	return s.records != nil && s.config.AdminToken != ""
+}
+
+// moderationStore is where moderators look for entries: the submission
+// database when configured, otherwise the publishing store itself
+func (s *Server) moderationStore() EntryStore {
+	if s.records != nil {
+		return s.records
+	}
+	return s.store
+}
+
+func (s *Server) handleAdminListEntries(c *gin.Context) {
+	status := StatusPending
+	if param := c.Query("status"); param == "all" {
+		status = ""
+	} else if param != "" {
+		parsed, err := ParseEntryStatus(param)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		status = parsed
+	}
+
+	store := s.moderationStore()
+	if store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errNoStore.Error()})
+		return
+	}
+
+	entries, err := store.ListEntries(c.Request.Context(), status)
+	if err != nil {
+		serverDebugLog("Failed to list %s entries: %v", status, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list entries"})
+		return
+	}
+	if entries == nil {
+		entries = []*GuestbookEntry{}
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+func (s *Server) handleAdminGetEntry(c *gin.Context) {
+	store := s.moderationStore()
+	if store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errNoStore.Error()})
+		return
+	}
+
+	entry, err := store.GetEntry(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+func (s *Server) handleAdminEditEntry(c *gin.Context) {
+	var req adminEditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	entry, err := s.editEntry(c.Request.Context(), c.Param("id"), req.Name, req.Message)
+	if err != nil {
+		respondAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+func (s *Server) handleAdminDecision(status EntryStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req adminDecisionRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+				return
+			}
+		}
+		if req.Reason == "" {
+			req.Reason = fmt.Sprintf("%s by moderator", moderationVerb(status))
+		}
+
+		entry, err := s.moderateEntry(c.Request.Context(), c.Param("id"), status, req.Reason)
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+func moderationVerb(status EntryStatus) string {
+	switch status {
+	case StatusApproved:
+		return "approved"
+	case StatusRejected:
+		return "rejected"
+	case StatusSpam:
+		return "marked as spam"
+	default:
+		return "set to " + string(status)
+	}
+}
+
+func respondAdminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrEntryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+	case errors.Is(err, errNotEditable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		serverDebugLog("Admin request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// moderateEntry applies a moderator's decision. With a submission database the
+// decision is recorded there and approving publishes the entry through the
+// configured store; without one the store itself is updated.
+func (s *Server) moderateEntry(ctx context.Context, id string, status EntryStatus, reason string) (*GuestbookEntry, error) {
+	if s.records == nil {
+		if s.store == nil {
+			return nil, errNoStore
+		}
+		entry, err := s.store.GetEntry(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.store.UpdateEntryStatus(ctx, id, status); err != nil {
+			return nil, err
+		}
+		entry.Status = status
+		return entry, nil
+	}
+
+	entry, err := s.records.GetEntry(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status == status {
+		return entry, nil
+	}
+
+	if status == StatusApproved || entry.Status == StatusApproved {
+		if s.store == nil {
+			return nil, errNoStore
+		}
+	}
+	if status == StatusApproved {
+		if status, err = s.publishEntry(ctx, entry); err != nil {
+			return nil, err
+		}
+		if status != StatusApproved {
+			reason += awaitingMerge
+		}
+	} else if entry.Status == StatusApproved {
+		if err := s.store.UpdateEntryStatus(ctx, id, status); err != nil {
+			return nil, fmt.Errorf("failed to unpublish entry: %w", err)
+		}
+	}
+
+	if err := s.records.RecordDecision(ctx, id, status, reason); err != nil {
+		return nil, err
+	}
+	entry.Status = status
+	entry.Reason = reason
+	entry.Moderated = true
+
+	s.sendAkismetFeedback(entry)
+	return entry, nil
+}
+
+// awaitingMerge is added to the reason of entries the store opened a pull
+// request for instead of publishing them
+const awaitingMerge = "; waiting for its pull request to be merged"
+
+// publishEntry publishes an approved entry through the store and returns its
+// status there. A pull request the store opens for the entry is merged right
+// away, since the moderator has already reviewed it; if that fails, the entry
+// stays pending until the pull request is merged.
+func (s *Server) publishEntry(ctx context.Context, entry *GuestbookEntry) (EntryStatus, error) {
+	published, err := s.store.GetEntry(ctx, entry.ID)
+	if err != nil && !errors.Is(err, ErrEntryNotFound) {
+		return "", fmt.Errorf("failed to look up entry: %w", err)
+	}
+	if err != nil || (published.Status != StatusPending && published.Status != StatusApproved) {
+		copied := *entry
+		published = &copied
+		published.Status = StatusApproved
+		if err := s.store.CreateEntry(ctx, published); err != nil {
+			return "", fmt.Errorf("failed to publish entry: %w", err)
+		}
+	}
+	if published.Status == StatusPending {
+		if err := s.store.UpdateEntryStatus(ctx, entry.ID, StatusApproved); err != nil {
+			log.Printf("Failed to merge the pull request of approved guestbook entry %s: %v", entry.ID, err)
+			return StatusPending, nil
+		}
+	}
+	return StatusApproved, nil
+}
+
+// sendAkismetFeedback reports moderation decisions that disagree with Akismet's
+// verdict, which is how Akismet learns the spam this site receives. Reports are
+// sent in the background so bulk moderation is not slowed down.
+func (s *Server) sendAkismetFeedback(entry *GuestbookEntry) {
+	if s.akismet == nil || entry.UserIP == "" {
+		return
+	}
+
+	flagged := false
+	if check := entry.Check("akismet"); check != nil {
+		flagged = check.Verdict == VerdictSpam
+	}
+
+	var submit func(context.Context, AkismetComment) error
+	switch {
+	case entry.Status == StatusSpam && !flagged:
+		submit = s.akismet.SubmitSpam
+	case entry.Status == StatusApproved && flagged:
+		submit = s.akismet.SubmitHam
+	default:
+		return
+	}
+
+	comment := s.akismetComment(entry)
+
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := submit(ctx, comment); err != nil {
+			log.Printf("Failed to send Akismet feedback for entry %s: %v", entry.ID, err)
+		}
+	}()
+}
+
+// editEntry changes the name and/or message of a pending entry
+func (s *Server) editEntry(ctx context.Context, id string, name, message *string) (*GuestbookEntry, error) {
+	store := s.moderationStore()
+	if store == nil {
+		return nil, errNoStore
+	}
+	editor, ok := store.(EntryEditor)
+	if !ok {
+		return nil, fmt.Errorf("storage backend does not support editing entries")
+	}
+
+	entry, err := store.GetEntry(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status != StatusPending {
+		return nil, errNotEditable
+	}
+
+	if name != nil {
+		entry.Name = sanitizeString(*name)
+	}
+	if message != nil {
+		entry.Message = sanitizeString(*message)
+	}
+	if err := editor.UpdateEntry(ctx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
@@ -0,0 +1,331 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdminTestServer(t *testing.T) (*Server, *MockEntryStore, *MockEntryStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	server := New(&Config{
+		Port:              "8080",
+		AllowedOrigins:    []string{"*"},
+		RateLimitRequests: 100,
+		RateLimitWindow:   60,
+		AdminToken:        "secret-token",
+	})
+	store := &MockEntryStore{}
+	records := &MockEntryStore{entries: []*GuestbookEntry{
+		{ID: "pending-1", Name: "Jane", Message: "Hello there", Status: StatusPending,
+			Checks: []SpamCheckResult{{Check: "akismet", Verdict: VerdictPass}}},
+		{ID: "spam-1", Name: "Bot", Message: "casino", Status: StatusSpam},
+	}}
+	server.store = store
+	server.records = records
+	return server, store, records
+}
+
+func adminRequest(t *testing.T, server *Server, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, path, &buf)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret-token")
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	server, _, _ := newAdminTestServer(t)
+
+	for _, auth := range []string{"", "Bearer wrong-token", "secret-token"} {
+		req, err := http.NewRequest("GET", "/admin/entries", nil)
+		require.NoError(t, err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "Authorization: %q", auth)
+	}
+}
+
+func TestAdmin_DisabledWithoutToken(t *testing.T) {
+	server := New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 100, RateLimitWindow: 60})
+
+	req, err := http.NewRequest("GET", "/admin/entries", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAdmin_ListEntries(t *testing.T) {
+	server, _, _ := newAdminTestServer(t)
+
+	rr := adminRequest(t, server, "GET", "/admin/entries", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Entries []GuestbookEntry `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Entries, 1)
+	assert.Equal(t, "pending-1", response.Entries[0].ID)
+	assert.Equal(t, VerdictPass, response.Entries[0].Checks[0].Verdict)
+
+	rr = adminRequest(t, server, "GET", "/admin/entries?status=all", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response.Entries, 2)
+
+	rr = adminRequest(t, server, "GET", "/admin/entries?status=bogus", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAdmin_ApprovePublishesEntry(t *testing.T) {
+	server, store, records := newAdminTestServer(t)
+
+	rr := adminRequest(t, server, "POST", "/admin/entries/pending-1/approve", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	require.Len(t, store.entries, 1)
+	assert.Equal(t, "pending-1", store.entries[0].ID)
+	assert.Equal(t, StatusApproved, store.entries[0].Status)
+
+	entry, err := records.GetEntry(context.Background(), "pending-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusApproved, entry.Status)
+	assert.Equal(t, "approved by moderator", entry.Reason)
+	assert.True(t, entry.Moderated)
+
+	// Approving twice does not publish twice
+	rr = adminRequest(t, server, "POST", "/admin/entries/pending-1/approve", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, store.entries, 1)
+}
+
+func TestAdmin_MarkSpamWithReason(t *testing.T) {
+	server, store, records := newAdminTestServer(t)
+
+	rr := adminRequest(t, server, "POST", "/admin/entries/pending-1/spam", map[string]string{"reason": "SEO link"})
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, store.entries)
+
+	entry, err := records.GetEntry(context.Background(), "pending-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusSpam, entry.Status)
+	assert.Equal(t, "SEO link", entry.Reason)
+
+	rr = adminRequest(t, server, "POST", "/admin/entries/missing/reject", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAdmin_EditEntry(t *testing.T) {
+	server, _, records := newAdminTestServer(t)
+
+	rr := adminRequest(t, server, "PATCH", "/admin/entries/pending-1", map[string]string{"message": "Hello <b>there</b>"})
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	entry, err := records.GetEntry(context.Background(), "pending-1")
+	require.NoError(t, err)
+	assert.Equal(t, "Jane", entry.Name)
+	assert.Equal(t, "Hello &lt;b&gt;there&lt;/b&gt;", entry.Message)
+
+	rr = adminRequest(t, server, "PATCH", "/admin/entries/pending-1", map[string]string{"name": " "})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = adminRequest(t, server, "PATCH", "/admin/entries/spam-1", map[string]string{"message": "edited"})
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestGuestbookSubmission_QueuedForModeration(t *testing.T) {
+	server, store, records := newAdminTestServer(t)
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+
+	payload := map[string]string{
+		"name":                 "Test User",
+		"message":              "This is a test message",
+		"g-recaptcha-response": "mock-response",
+	}
+	jsonData, _ := json.Marshal(payload)
+	req, err := http.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, store.entries, "entries are only published once approved")
+	pending, err := records.ListEntries(context.Background(), StatusPending)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+}
+
+func TestAdmin_SendsAkismetFeedback(t *testing.T) {
+	server, _, records := newAdminTestServer(t)
+
+	var mu sync.Mutex
+	var calls []string
+	akismet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		mu.Lock()
+		calls = append(calls, r.URL.Path+" "+r.Form.Get("comment_content"))
+		mu.Unlock()
+		w.Write([]byte("Thanks for making the web a better place."))
+	}))
+	defer akismet.Close()
+	server.akismet = NewAkismetClient("test-key", "https://example.com", WithBaseURL(akismet.URL))
+
+	records.entries = append(records.entries,
+		&GuestbookEntry{ID: "missed", Message: "cheap &amp; fast", UserIP: "192.0.2.1", Status: StatusPending,
+			Checks: []SpamCheckResult{{Check: "akismet", Verdict: VerdictPass}}},
+		&GuestbookEntry{ID: "false-positive", Message: "hello", UserIP: "192.0.2.2", Status: StatusSpam,
+			Checks: []SpamCheckResult{{Check: "akismet", Verdict: VerdictSpam}}},
+		&GuestbookEntry{ID: "agreed", Message: "hi", UserIP: "192.0.2.3", Status: StatusPending,
+			Checks: []SpamCheckResult{{Check: "akismet", Verdict: VerdictPass}}},
+	)
+
+	require.Equal(t, http.StatusOK, adminRequest(t, server, "POST", "/admin/entries/missed/spam", nil).Code)
+	require.Equal(t, http.StatusOK, adminRequest(t, server, "POST", "/admin/entries/false-positive/approve", nil).Code)
+	require.Equal(t, http.StatusOK, adminRequest(t, server, "POST", "/admin/entries/agreed/approve", nil).Code)
+	server.tasks.Wait()
+
+	assert.ElementsMatch(t, []string{"/submit-spam cheap & fast", "/submit-ham hello"}, calls)
+}
+
+func TestGuestbookSubmission_AutoAccepted(t *testing.T) {
+	server, store, records := newAdminTestServer(t)
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+	server.config.SpamAcceptThreshold = 0.3
+
+	submit := func(message string) {
+		jsonData, _ := json.Marshal(map[string]string{
+			"name":                 "Test User",
+			"message":              message,
+			"g-recaptcha-response": "mock-response",
+		})
+		req, err := http.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	submit("Lovely site, thanks for sharing")
+	require.Len(t, store.entries, 1, "clean entries are published without review")
+	assert.Equal(t, StatusApproved, store.entries[0].Status)
+	approved, err := records.ListEntries(context.Background(), StatusApproved)
+	require.NoError(t, err)
+	require.Len(t, approved, 1)
+	assert.Equal(t, "auto-accepted with spam score 0.00", approved[0].Reason)
+
+	submit("Feel free to reach out")
+	assert.Len(t, store.entries, 1, "entries with weak spam signals wait for review")
+	pending, err := records.ListEntries(context.Background(), StatusPending)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+}
+
+// pullRequestStore publishes entries the way GitHubClient does by default: new
+// entries wait in a pull request until it is merged
+type pullRequestStore struct {
+	MockEntryStore
+	mergeErr error
+}
+
+func (p *pullRequestStore) CreateEntry(ctx context.Context, entry *GuestbookEntry) error {
+	entry.Status = StatusPending
+	copied := *entry
+	return p.MockEntryStore.CreateEntry(ctx, &copied)
+}
+
+func (p *pullRequestStore) UpdateEntryStatus(ctx context.Context, id string, status EntryStatus) error {
+	if p.mergeErr != nil {
+		return p.mergeErr
+	}
+	return p.MockEntryStore.UpdateEntryStatus(ctx, id, status)
+}
+
+func TestAdmin_ApproveMergesPullRequest(t *testing.T) {
+	server, _, records := newAdminTestServer(t)
+	store := &pullRequestStore{}
+	server.store = store
+
+	rr := adminRequest(t, server, "POST", "/admin/entries/pending-1/approve", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, store.entries, 1)
+	assert.Equal(t, StatusApproved, store.entries[0].Status, "the moderator's approval merges the pull request")
+	entry, err := records.GetEntry(context.Background(), "pending-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusApproved, entry.Status)
+}
+
+func TestAdmin_ApproveRecordsUnmergedPullRequest(t *testing.T) {
+	server, _, records := newAdminTestServer(t)
+	store := &pullRequestStore{mergeErr: assert.AnError}
+	server.store = store
+
+	rr := adminRequest(t, server, "POST", "/admin/entries/pending-1/approve", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"pending"`)
+	entry, err := records.GetEntry(context.Background(), "pending-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, entry.Status, "the entry is not published until its pull request is merged")
+	assert.Equal(t, "approved by moderator; waiting for its pull request to be merged", entry.Reason)
+
+	// Approving again retries the merge instead of opening another pull request
+	store.mergeErr = nil
+	rr = adminRequest(t, server, "POST", "/admin/entries/pending-1/approve", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, store.entries, 1)
+	assert.Equal(t, StatusApproved, store.entries[0].Status)
+	entry, err = records.GetEntry(context.Background(), "pending-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusApproved, entry.Status)
+}
+
+func TestGuestbookSubmission_MessageMatchesOutcome(t *testing.T) {
+	server, _, records := newAdminTestServer(t)
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+	server.config.SpamAcceptThreshold = 0.3
+
+	submit := func(message string) string {
+		jsonData, _ := json.Marshal(map[string]string{"name": "Test User", "message": message, "g-recaptcha-response": "mock-response"})
+		req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	assert.Contains(t, submit("Lovely site, thanks for sharing"), "It has been published")
+	assert.Contains(t, submit("Feel free to reach out"), "It will be reviewed")
+
+	// Auto-accepted entries still wait for their pull request to be merged
+	server.store = &pullRequestStore{}
+	assert.Contains(t, submit("Great photos, thanks"), "It will be reviewed")
+	pending, err := records.ListEntries(context.Background(), StatusPending)
+	require.NoError(t, err)
+	assert.Equal(t, "auto-accepted with spam score 0.00; waiting for its pull request to be merged", pending[len(pending)-1].Reason)
+}
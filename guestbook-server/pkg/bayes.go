@@ -0,0 +1,171 @@
+package guestbook_server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BayesModel is a multinomial naive Bayes text classifier over the words of
+// a submission's name and message, trained from moderation history
+type BayesModel struct {
+	SpamDocs  int            `json:"spam_docs"`
+	HamDocs   int            `json:"ham_docs"`
+	SpamWords map[string]int `json:"spam_words"`
+	HamWords  map[string]int `json:"ham_words"`
+	SpamTotal int            `json:"spam_total"` // Sum of SpamWords
+	HamTotal  int            `json:"ham_total"`  // Sum of HamWords
+}
+
+// NewBayesModel returns an untrained model
+func NewBayesModel() *BayesModel {
+	return &BayesModel{SpamWords: make(map[string]int), HamWords: make(map[string]int)}
+}
+
+// bayesTokens splits text into the normalized words the model counts
+func bayesTokens(text string) []string {
+	return wordTokens(normalizeText(text))
+}
+
+// Train adds one document to the model
+func (m *BayesModel) Train(text string, spam bool) {
+	words, total := m.HamWords, &m.HamTotal
+	if spam {
+		words, total = m.SpamWords, &m.SpamTotal
+		m.SpamDocs++
+	} else {
+		m.HamDocs++
+	}
+	for _, token := range bayesTokens(text) {
+		words[token]++
+		*total++
+	}
+}
+
+// Trained reports whether the model has seen both spam and ham
+func (m *BayesModel) Trained() bool {
+	return m != nil && m.SpamDocs > 0 && m.HamDocs > 0
+}
+
+// SpamProbability returns the probability that text is spam, using Laplace
+// smoothing so unseen words do not dominate
+func (m *BayesModel) SpamProbability(text string) float64 {
+	if !m.Trained() {
+		return 0.5
+	}
+
+	vocabulary := len(m.SpamWords)
+	for word := range m.HamWords {
+		if _, ok := m.SpamWords[word]; !ok {
+			vocabulary++
+		}
+	}
+
+	docs := float64(m.SpamDocs + m.HamDocs)
+	logSpam := math.Log(float64(m.SpamDocs) / docs)
+	logHam := math.Log(float64(m.HamDocs) / docs)
+	for _, token := range bayesTokens(text) {
+		logSpam += math.Log(float64(m.SpamWords[token]+1) / float64(m.SpamTotal+vocabulary))
+		logHam += math.Log(float64(m.HamWords[token]+1) / float64(m.HamTotal+vocabulary))
+	}
+	return 1 / (1 + math.Exp(logHam-logSpam))
+}
+
+// LoadBayesModel reads a model written by Save
+func LoadBayesModel(path string) (*BayesModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Bayes model: %w", err)
+	}
+	model := NewBayesModel()
+	if err := json.Unmarshal(data, model); err != nil {
+		return nil, fmt.Errorf("failed to parse Bayes model %s: %w", path, err)
+	}
+	return model, nil
+}
+
+// Save writes the model as JSON, replacing any existing file atomically so a
+// running server never loads a partial model
+func (m *BayesModel) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode Bayes model: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".bayes-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write Bayes model: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write Bayes model: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write Bayes model: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write Bayes model: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// entryText is the text the classifier sees for a stored entry. Entries are
+// stored HTML-escaped, so they are unescaped to match incoming submissions.
+func entryText(entry *GuestbookEntry) string {
+	return html.UnescapeString(entry.Name) + " " + html.UnescapeString(entry.Message)
+}
+
+// TrainBayesModel builds a model from moderation history. Published entries in
+// dataDir and entries a moderator approved in records are ham; entries a
+// moderator rejected or marked as spam are spam. Statuses the spam checks
+// assigned are left out, so the model does not learn from its own verdicts,
+// nor from legitimate submissions turned away by outages or missing tokens.
+// Either source may be empty.
+func TrainBayesModel(ctx context.Context, dataDir string, records EntryStore) (*BayesModel, error) {
+	model := NewBayesModel()
+	seen := make(map[string]bool)
+
+	if dataDir != "" {
+		files, err := filepath.Glob(filepath.Join(dataDir, "*.yml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", file, err)
+			}
+			var entry GuestbookEntry
+			if err := yaml.Unmarshal(data, &entry); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+			}
+			seen[entry.ID] = true
+			model.Train(entryText(&entry), false)
+		}
+	}
+
+	if records != nil {
+		for _, status := range []EntryStatus{StatusApproved, StatusRejected, StatusSpam} {
+			entries, err := records.ListEntries(ctx, status)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s entries: %w", status, err)
+			}
+			for _, entry := range entries {
+				// Approved entries are usually published too
+				if !entry.Moderated || seen[entry.ID] {
+					continue
+				}
+				seen[entry.ID] = true
+				model.Train(entryText(entry), status != StatusApproved)
+			}
+		}
+	}
+
+	return model, nil
+}
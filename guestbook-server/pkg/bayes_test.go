@@ -0,0 +1,105 @@
+package guestbook_server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTrainedBayesModel() *BayesModel {
+	model := NewBayesModel()
+	for _, spam := range []string{
+		"Cheap replica watches, best prices online",
+		"Buy cheap pills online without prescription",
+		"Best online casino bonus, play now",
+		"Cheap SEO services, rank your website today",
+	} {
+		model.Train(spam, true)
+	}
+	for _, ham := range []string{
+		"Lovely site, thanks for sharing your photos",
+		"Found your blog while searching for Hugo themes, cheers!",
+		"Das ist ziemlich cool",
+		"Great write-up on the home lab, thanks",
+	} {
+		model.Train(ham, false)
+	}
+	return model
+}
+
+func TestBayesModel_Classifies(t *testing.T) {
+	model := newTrainedBayesModel()
+	require.True(t, model.Trained())
+
+	assert.Greater(t, model.SpamProbability("cheap watches and pills online"), 0.9)
+	assert.Less(t, model.SpamProbability("Thanks for sharing, lovely photos"), 0.1)
+	assert.Equal(t, 0.5, NewBayesModel().SpamProbability("anything"), "an untrained model has no opinion")
+}
+
+func TestBayesModel_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bayes.json")
+	model := newTrainedBayesModel()
+	require.NoError(t, model.Save(path))
+
+	loaded, err := LoadBayesModel(path)
+	require.NoError(t, err)
+	assert.Equal(t, model, loaded)
+
+	_, err = LoadBayesModel(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestTrainBayesModel(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "entry1.yml"),
+		[]byte("_id: published-1\nname: Jane\nmessage: Lovely site, thanks for sharing\ndate: 1\n"), 0644))
+
+	records := newTestSQLiteStore(t)
+	for _, entry := range []*GuestbookEntry{
+		{ID: "published-1", Name: "Jane", Message: "Lovely site, thanks for sharing", Status: StatusApproved},
+		{ID: "approved-1", Name: "Joe", Message: "Great &quot;photos&quot;", Status: StatusPending},
+		{ID: "spam-1", Name: "Bot", Message: "Cheap replica watches", Status: StatusPending},
+		{ID: "rejected-1", Name: "Troll", Message: "Offensive rant", Status: StatusPending},
+		{ID: "auto-spam-1", Name: "Human", Message: "Hello without JavaScript", Status: StatusSpam, Reason: "formtoken: spam"},
+		{ID: "auto-approved-1", Name: "Bayes", Message: "Whatever the model liked", Status: StatusApproved, Reason: "auto-accepted"},
+		{ID: "pending-1", Name: "Maybe", Message: "Unreviewed", Status: StatusPending},
+	} {
+		require.NoError(t, records.CreateEntry(ctx, entry))
+	}
+	require.NoError(t, records.RecordDecision(ctx, "approved-1", StatusApproved, "approved by moderator"))
+	require.NoError(t, records.RecordDecision(ctx, "spam-1", StatusSpam, "marked as spam by moderator"))
+	require.NoError(t, records.RecordDecision(ctx, "rejected-1", StatusRejected, "rude"))
+
+	model, err := TrainBayesModel(ctx, dataDir, records)
+	require.NoError(t, err)
+	assert.Equal(t, 2, model.HamDocs, "published entries are not counted twice")
+	assert.Equal(t, 2, model.SpamDocs, "entries a moderator rejected or marked as spam")
+	assert.Equal(t, 1, model.HamWords["photos"])
+	assert.Equal(t, 1, model.SpamWords["rant"])
+	assert.Zero(t, model.HamWords["quot"], "stored entries are unescaped")
+	assert.Zero(t, model.SpamWords["javascript"], "spam labelled by the checks is not training data")
+	assert.Zero(t, model.HamWords["liked"], "auto-accepted entries are not training data")
+}
+
+func TestBayesChecker(t *testing.T) {
+	sub := newTestSubmission()
+	sub.Request.Message = "Cheap replica watches online"
+
+	result := bayesChecker{weight: 1}.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSkipped, result.Verdict)
+
+	result = bayesChecker{model: newTrainedBayesModel(), weight: 1}.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSpam, result.Verdict)
+	assert.Greater(t, result.SpamScore, 0.8)
+	require.NotNil(t, result.Score)
+
+	sub.Request.Message = "Lovely photos, thanks for sharing"
+	result = bayesChecker{model: newTrainedBayesModel(), weight: 1}.Check(context.Background(), sub)
+	assert.Equal(t, VerdictPass, result.Verdict)
+	assert.Zero(t, result.SpamScore)
+}
@@ -0,0 +1,267 @@
+package guestbook_server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// CaptchaVerifier checks the token a captcha widget added to the form
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, response, remoteIP string) (bool, error)
+}
+
+// ScoredCaptchaVerifier is implemented by verifiers that also report a score,
+// such as reCAPTCHA v3
+type ScoredCaptchaVerifier interface {
+	VerifyWithScore(ctx context.Context, response, remoteIP string) (bool, float64, error)
+}
+
+// captchaProvider describes a supported captcha service
+type captchaProvider struct {
+	Label     string // Name shown in messages
+	Field     string // Form field the widget submits its token in
+	VerifyURL string // Default siteverify endpoint
+}
+
+// captchaProviders are the values of Config.CaptchaProvider
+var captchaProviders = map[string]captchaProvider{
+	"recaptcha": {Label: "reCAPTCHA", Field: "g-recaptcha-response", VerifyURL: "https://www.google.com/recaptcha/api/siteverify"},
+	"hcaptcha":  {Label: "hCaptcha", Field: "h-captcha-response", VerifyURL: "https://api.hcaptcha.com/siteverify"},
+	"turnstile": {Label: "Turnstile", Field: "cf-turnstile-response", VerifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify"},
+	"pow":       {Label: "proof-of-work", Field: "pow-response"},
+}
+
+// captchaProvider returns the configured provider, reCAPTCHA by default
+func (s *Server) captchaProvider() captchaProvider {
+	if s.config != nil {
+		if provider, ok := captchaProviders[s.config.CaptchaProvider]; ok {
+			return provider
+		}
+	}
+	return captchaProviders["recaptcha"]
+}
+
+// captchaField returns the form field holding the captcha token
+func (s *Server) captchaField() string {
+	if s.config != nil && s.config.CaptchaField != "" {
+		return s.config.CaptchaField
+	}
+	return s.captchaProvider().Field
+}
+
+// defaultCaptchaMaxTokenAge is how long after the challenge was solved a
+// token is accepted. reCAPTCHA tokens also expire after two minutes.
+const defaultCaptchaMaxTokenAge = 2 * time.Minute
+
+// tokenPolicy rejects captcha tokens minted for other sites or solved too
+// long ago
+type tokenPolicy struct {
+	hostnames []string      // Accepted hostnames; any when empty
+	maxAge    time.Duration // Oldest accepted token; any age when zero
+	nowFunc   func() time.Time
+}
+
+// check returns why a token with the siteverify hostname and challenge_ts
+// is not accepted, or nil
+func (p tokenPolicy) check(label, hostname, challengeTS string) error {
+	if len(p.hostnames) > 0 && !slices.ContainsFunc(p.hostnames, func(h string) bool { return strings.EqualFold(h, hostname) }) {
+		return fmt.Errorf("%s hostname mismatch: expected one of %v, got '%s'", label, p.hostnames, hostname)
+	}
+	if p.maxAge <= 0 {
+		return nil
+	}
+	solved, err := time.Parse(time.RFC3339, challengeTS)
+	if err != nil {
+		return fmt.Errorf("%s response has an invalid challenge_ts '%s'", label, challengeTS)
+	}
+	now := time.Now()
+	if p.nowFunc != nil {
+		now = p.nowFunc()
+	}
+	if age := now.Sub(solved); age > p.maxAge {
+		return fmt.Errorf("%s token is too old: solved %s ago (maximum %s)", label, age.Round(time.Second), p.maxAge)
+	}
+	return nil
+}
+
+// captchaHostnames returns the hostnames captcha tokens must be minted for:
+// Config.CaptchaHostnames, or else the hosts of Config.AllowedOrigins
+func captchaHostnames(config *Config) []string {
+	if len(config.CaptchaHostnames) > 0 {
+		return config.CaptchaHostnames
+	}
+	var hostnames []string
+	for _, origin := range config.AllowedOrigins {
+		if u, err := url.Parse(origin); err == nil && u.Hostname() != "" {
+			hostnames = append(hostnames, u.Hostname())
+		}
+	}
+	return hostnames
+}
+
+// newCaptchaVerifier creates the verifier for Config.CaptchaProvider. The
+// proof-of-work verifier is also returned so its challenges can be served.
+func newCaptchaVerifier(config *Config) (CaptchaVerifier, *ProofOfWork) {
+	secret := config.CaptchaSecretKey
+	if secret == "" {
+		secret = config.RecaptchaSecretKey
+	}
+	policy := tokenPolicy{hostnames: captchaHostnames(config), maxAge: defaultCaptchaMaxTokenAge}
+	if config.CaptchaMaxTokenAge > 0 {
+		policy.maxAge = time.Duration(config.CaptchaMaxTokenAge) * time.Second
+	}
+
+	opts := []ClientOption{WithBaseURL(config.CaptchaVerifyURL)}
+
+	switch config.CaptchaProvider {
+	case "pow":
+		pow := NewProofOfWork(config.ProofOfWorkSecret, config.ProofOfWorkDifficulty)
+		return pow, pow
+	case "hcaptcha", "turnstile":
+		client := NewSiteverifyClient(config.CaptchaProvider, secret, opts...)
+		if client != nil {
+			client.policy = policy
+		}
+		return client, nil
+	case "", "recaptcha":
+	default:
+		log.Printf("Unknown captcha provider %q, using reCAPTCHA", config.CaptchaProvider)
+	}
+	client := NewRecaptchaClient(secret, config.RecaptchaScoreThreshold, opts...)
+	if client != nil {
+		client.policy = policy
+		if config.RecaptchaVersion == "v2" {
+			client.version = "v2"
+		}
+		if len(config.RecaptchaActions) > 0 {
+			client.actions = config.RecaptchaActions
+		}
+	}
+	return client, nil
+}
+
+// SiteverifyResponse is the response of the hCaptcha and Turnstile siteverify
+// endpoints. Fields only one of them returns are left empty by the other.
+type SiteverifyResponse struct {
+	Success     bool     `json:"success"`
+	ChallengeTS string   `json:"challenge_ts"`
+	Hostname    string   `json:"hostname"`
+	ErrorCodes  []string `json:"error-codes"`
+	Action      string   `json:"action"` // Turnstile
+	Credit      bool     `json:"credit"` // hCaptcha
+}
+
+// SiteverifyClient verifies hCaptcha and Turnstile tokens, which share the
+// reCAPTCHA-style siteverify protocol but report no score
+type SiteverifyClient struct {
+	provider  string
+	secretKey string
+	verifyURL string
+	policy    tokenPolicy
+	client    *http.Client
+}
+
+// NewSiteverifyClient creates a client for the "hcaptcha" or "turnstile"
+// provider, or returns nil without a secret key
+func NewSiteverifyClient(provider, secretKey string, opts ...ClientOption) *SiteverifyClient {
+	if secretKey == "" {
+		return nil
+	}
+	o := newClientOptions(opts)
+	verifyURL := o.baseURL
+	if verifyURL == "" {
+		verifyURL = captchaProviders[provider].VerifyURL
+	}
+	return &SiteverifyClient{
+		provider:  provider,
+		secretKey: secretKey,
+		verifyURL: verifyURL,
+		client:    o.httpClient,
+	}
+}
+
+func (v *SiteverifyClient) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	if v == nil {
+		debugLog("Captcha client is nil, skipping verification")
+		return true, nil // Skip verification if not configured
+	}
+	label := captchaProviders[v.provider].Label
+
+	data := url.Values{}
+	data.Set("secret", v.secretKey)
+	data.Set("response", response)
+	data.Set("remoteip", remoteIP)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", v.verifyURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	debugLog("Sending request to %s siteverify API", label)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		debugLog("%s API request failed: %v", label, err)
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s API returned status %d", label, resp.StatusCode)
+	}
+
+	var result SiteverifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		debugLog("Failed to decode %s response: %v", label, err)
+		return false, err
+	}
+	debugLog("%s response: Success=%t, Hostname=%s, Action=%s, ErrorCodes=%v", label, result.Success, result.Hostname, result.Action, result.ErrorCodes)
+
+	if !result.Success {
+		if siteverifyConfigError(result.ErrorCodes) {
+			return false, fmt.Errorf("%s verification failed: %v", label, result.ErrorCodes)
+		}
+		return false, nil
+	}
+	if err := v.policy.check(label, result.Hostname, result.ChallengeTS); err != nil {
+		debugLog("%v", err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// siteverifyConfigError reports whether siteverify error codes point at our
+// configuration or the provider rather than the visitor's token.
+// Configuration problems are errors, so the failure policy applies instead
+// of visitors being blamed.
+func siteverifyConfigError(codes []string) bool {
+	for _, code := range codes {
+		if strings.HasPrefix(code, "missing-input-secret") || strings.HasPrefix(code, "invalid-input-secret") ||
+			code == "sitekey-secret-mismatch" || code == "internal-error" {
+			return true
+		}
+	}
+	return false
+}
+
+// captchaToken reads the captcha token from field of a JSON body or form
+func captchaToken(c *gin.Context, field string) string {
+	if strings.Contains(c.GetHeader("Content-Type"), "application/json") {
+		var body map[string]interface{}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return ""
+		}
+		token, _ := body[field].(string)
+		return token
+	}
+	return c.PostForm(field)
+}
@@ -0,0 +1,195 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSiteverifyServer is a local stand-in for a siteverify endpoint that
+// accepts the token "good"
+func newSiteverifyServer(t *testing.T, extra string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "test-secret", r.PostForm.Get("secret"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostForm.Get("response") {
+		case "good":
+			challengeTS := time.Now().UTC().Format(time.RFC3339)
+			w.Write([]byte(`{"success":true,"hostname":"b10a.co","challenge_ts":"` + challengeTS + `"` + extra + `}`))
+		case "misconfigured":
+			w.Write([]byte(`{"success":false,"error-codes":["invalid-input-secret"]}`))
+		case "down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSiteverifyClient(t *testing.T) {
+	for _, provider := range []string{"hcaptcha", "turnstile"} {
+		t.Run(provider, func(t *testing.T) {
+			client := NewSiteverifyClient(provider, "test-secret")
+			require.NotNil(t, client)
+			assert.Equal(t, captchaProviders[provider].VerifyURL, client.verifyURL)
+			client.verifyURL = newSiteverifyServer(t, "").URL
+			ctx := context.Background()
+
+			valid, err := client.Verify(ctx, "good", "192.0.2.1")
+			assert.NoError(t, err)
+			assert.True(t, valid)
+
+			valid, err = client.Verify(ctx, "bad", "192.0.2.1")
+			assert.NoError(t, err, "invalid tokens are spam, not errors")
+			assert.False(t, valid)
+
+			_, err = client.Verify(ctx, "misconfigured", "192.0.2.1")
+			assert.ErrorContains(t, err, "invalid-input-secret")
+
+			_, err = client.Verify(ctx, "down", "192.0.2.1")
+			assert.Error(t, err)
+		})
+	}
+
+	assert.Nil(t, NewSiteverifyClient("hcaptcha", ""))
+	var unconfigured *SiteverifyClient
+	valid, err := unconfigured.Verify(context.Background(), "anything", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestRecaptchaClient_VerifyURL(t *testing.T) {
+	verifier, _ := newCaptchaVerifier(&Config{
+		RecaptchaSecretKey: "test-secret",
+		CaptchaVerifyURL:   newSiteverifyServer(t, `,"score":0.9,"action":"submit"`).URL,
+	})
+	client, ok := verifier.(*RecaptchaClient)
+	require.True(t, ok)
+
+	valid, score, err := client.VerifyWithScore(context.Background(), "good", "192.0.2.1")
+	require.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, 0.9, score)
+}
+
+func TestNewCaptchaVerifier(t *testing.T) {
+	verifier, pow := newCaptchaVerifier(&Config{CaptchaProvider: "turnstile", CaptchaSecretKey: "test-secret"})
+	assert.IsType(t, &SiteverifyClient{}, verifier)
+	assert.Nil(t, pow)
+
+	verifier, pow = newCaptchaVerifier(&Config{CaptchaProvider: "pow"})
+	assert.NotNil(t, pow)
+	assert.Equal(t, pow, verifier)
+
+	verifier, _ = newCaptchaVerifier(&Config{CaptchaProvider: "bogus", RecaptchaSecretKey: "test-secret"})
+	assert.IsType(t, &RecaptchaClient{}, verifier)
+}
+
+func TestGuestbookSubmission_CaptchaProviders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	submit := func(server *Server, form url.Values, asJSON bool) *httptest.ResponseRecorder {
+		var req *http.Request
+		if asJSON {
+			body := map[string]string{}
+			for key := range form {
+				body[key] = form.Get(key)
+			}
+			data, _ := json.Marshal(body)
+			req = httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(data))
+			req.Header.Set("Content-Type", "application/json")
+		} else {
+			req = httptest.NewRequest("POST", "/guestbook", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+	newServer := func(config *Config) (*Server, *MockEntryStore) {
+		config.AllowedOrigins = []string{"*"}
+		config.RateLimitRequests = 100
+		config.RateLimitWindow = 60
+		config.CaptchaSecretKey = "test-secret"
+		config.CaptchaVerifyURL = newSiteverifyServer(t, "").URL
+		server := New(config)
+		store := &MockEntryStore{}
+		server.store = store
+		return server, store
+	}
+
+	for _, asJSON := range []bool{false, true} {
+		server, store := newServer(&Config{CaptchaProvider: "turnstile"})
+		rr := submit(server, url.Values{"name": {"Jane"}, "message": {"Hi"}, "cf-turnstile-response": {"good"}}, asJSON)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Len(t, store.entries, 1)
+
+		// The reCAPTCHA field is not read for other providers
+		rr = submit(server, url.Values{"name": {"Jane"}, "message": {"Hi again"}, "g-recaptcha-response": {"good"}}, asJSON)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "Turnstile verification is required")
+	}
+
+	server, store := newServer(&Config{CaptchaProvider: "hcaptcha"})
+	rr := submit(server, url.Values{"name": {"Jane"}, "message": {"Hi"}, "h-captcha-response": {"bad"}}, false)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "hCaptcha verification failed")
+	assert.Empty(t, store.entries)
+
+	server, store = newServer(&Config{CaptchaProvider: "hcaptcha", CaptchaField: "captcha-token"})
+	rr = submit(server, url.Values{"name": {"Jane"}, "message": {"Hi"}, "captcha-token": {"good"}}, false)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, store.entries, 1)
+}
+
+func TestTokenPolicy(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	policy := tokenPolicy{hostnames: []string{"b10a.co", "localhost"}, maxAge: 2 * time.Minute, nowFunc: func() time.Time { return now }}
+	fresh := now.Add(-30 * time.Second).Format(time.RFC3339)
+
+	assert.NoError(t, policy.check("reCAPTCHA", "b10a.co", fresh))
+	assert.NoError(t, policy.check("reCAPTCHA", "B10A.co", fresh))
+	assert.ErrorContains(t, policy.check("reCAPTCHA", "spam-farm.example", fresh), "hostname mismatch")
+	assert.ErrorContains(t, policy.check("reCAPTCHA", "b10a.co", now.Add(-5*time.Minute).Format(time.RFC3339)), "too old")
+	assert.ErrorContains(t, policy.check("reCAPTCHA", "b10a.co", ""), "invalid challenge_ts")
+
+	// An empty policy accepts anything
+	assert.NoError(t, tokenPolicy{}.check("reCAPTCHA", "anywhere.example", ""))
+}
+
+func TestCaptchaHostnames(t *testing.T) {
+	assert.Equal(t, []string{"b10a.co", "localhost"}, captchaHostnames(&Config{
+		AllowedOrigins: []string{"https://b10a.co", "http://localhost:1313", "*"},
+	}))
+	assert.Equal(t, []string{"example.com"}, captchaHostnames(&Config{
+		AllowedOrigins:   []string{"https://b10a.co"},
+		CaptchaHostnames: []string{"example.com"},
+	}))
+	assert.Empty(t, captchaHostnames(&Config{AllowedOrigins: []string{"*"}}))
+}
+
+func TestSiteverifyClient_RejectsOtherHostnames(t *testing.T) {
+	verifier, _ := newCaptchaVerifier(&Config{
+		CaptchaProvider:  "hcaptcha",
+		CaptchaSecretKey: "test-secret",
+		CaptchaVerifyURL: newSiteverifyServer(t, "").URL,
+		CaptchaHostnames: []string{"example.com"},
+	})
+	valid, err := verifier.Verify(context.Background(), "good", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.False(t, valid, "the stand-in reports the token was minted on b10a.co")
+}
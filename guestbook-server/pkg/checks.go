@@ -0,0 +1,257 @@
+package guestbook_server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultSpamChecks is the order checks run in unless Config.SpamChecks overrides it
+var defaultSpamChecks = []string{"ip", "honeypot", "formtoken", "duplicate", "domains", "recaptcha", "akismet", "heuristics", "bayes"}
+
+// spamCheckers returns the available checkers by name, built from the server's
+// current clients
+func (s *Server) spamCheckers() map[string]SpamChecker {
+	rules := s.spamRules()
+	return map[string]SpamChecker{
+		"ip":        ipChecker{allowed: s.ipAllowlist, blocked: s.ipBlocklist},
+		"honeypot":  honeypotChecker{weight: s.spamWeight("honeypot")},
+		"formtoken": formTokenChecker{tokens: s.formTokens, weight: s.spamWeight("formtoken")},
+		"duplicate": duplicateChecker{tracker: s.duplicates, weight: s.spamWeight("duplicate")},
+		"domains": domainChecker{
+			allowed:    []domainSet{newDomainSet(s.config.AllowedRedirectDomains), rules.allowed},
+			blocked:    []domainSet{rules.blocked, s.domainBlocklist},
+			shorteners: rules.shorteners,
+			weight:     s.spamWeight,
+		},
+		"recaptcha":  &captchaChecker{verifier: s.captcha, label: s.captchaProvider().Label, weight: s.spamWeight("recaptcha")},
+		"akismet":    &akismetChecker{client: s.akismet, comment: s.akismetComment, weight: s.spamWeight("akismet")},
+		"heuristics": heuristicsChecker{signals: s.spamSignals, weight: s.spamWeight},
+		"bayes":      bayesChecker{model: s.bayes, weight: s.spamWeight("bayes")},
+	}
+}
+
+// spamPipeline builds the pipeline configured by Config.SpamChecks and
+// Config.ShadowSpamChecks
+func (s *Server) spamPipeline() *Pipeline {
+	names := s.config.SpamChecks
+	if len(names) == 0 {
+		names = defaultSpamChecks
+	}
+	available := s.spamCheckers()
+	checkers := make([]SpamChecker, 0, len(names))
+	for _, name := range names {
+		if checker, ok := available[name]; ok {
+			checkers = append(checkers, checker)
+		} else {
+			serverDebugLog("Unknown spam check %q, skipping", name)
+		}
+	}
+	return NewPipeline(checkers, PipelineConfig{
+		Shadow:          s.config.ShadowSpamChecks,
+		Policy:          s.failurePolicy,
+		AcceptThreshold: s.spamAcceptThreshold(),
+		RejectThreshold: s.spamRejectThreshold(),
+	})
+}
+
+// silentSpam rejects a submission as spam while telling the client it was accepted
+func silentSpam(reason string) *Rejection {
+	return &Rejection{Status: StatusSpam, Reason: reason, Code: http.StatusOK}
+}
+
+// honeypotChecker flags submissions that filled in the hidden form field
+type honeypotChecker struct {
+	weight float64
+}
+
+func (honeypotChecker) Name() string { return "honeypot" }
+
+func (h honeypotChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	if sub.Request.Honeypot != "" {
+		serverDebugLog("Honeypot field detected from IP: %s", sub.Entry.UserIP)
+		return CheckResult{
+			Verdict:   VerdictSpam,
+			Details:   "hidden field was filled in",
+			SpamScore: h.weight,
+			Rejection: silentSpam("honeypot field was filled in"),
+		}
+	}
+	return CheckResult{Verdict: VerdictPass}
+}
+
+// captchaChecker verifies the captcha token. It keeps the name "recaptcha"
+// whatever the provider, so check lists, weights and failure policies carry
+// over when switching providers.
+type captchaChecker struct {
+	verifier CaptchaVerifier
+	label    string // Provider name shown in messages, e.g. "reCAPTCHA"
+	weight   float64
+}
+
+func (*captchaChecker) Name() string { return "recaptcha" }
+
+func (r *captchaChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	response := sub.Request.CaptchaResponse
+	if response == "" {
+		serverDebugLog("No %s response provided from IP: %s", r.label, sub.Entry.UserIP)
+		// A missing token is the client's fault rather than an outage, so it
+		// is rejected regardless of the failure policy
+		return CheckResult{
+			Verdict:  VerdictSpam,
+			Details:  fmt.Sprintf("no %s response", r.label),
+			Decisive: true,
+			Rejection: &Rejection{
+				Status: StatusRejected,
+				Reason: fmt.Sprintf("no %s response", r.label),
+				Code:   http.StatusBadRequest,
+				Error:  fmt.Sprintf("%s verification is required", r.label),
+			},
+		}
+	}
+
+	serverDebugLog("Verifying %s for IP: %s, Response length: %d", r.label, sub.Entry.UserIP, len(response))
+	if r.verifier == nil {
+		return r.failed(fmt.Errorf("%s client is nil", r.label), nil)
+	}
+	valid, score, err := verifyCaptcha(ctx, r.verifier, response, sub.Entry.UserIP)
+	if err != nil {
+		serverDebugLog("%s verification error: %v", r.label, err)
+		return r.failed(err, score)
+	}
+	if !valid {
+		serverDebugLog("%s verification failed: response was valid but score/success check failed for IP: %s", r.label, sub.Entry.UserIP)
+		return CheckResult{
+			Verdict:   VerdictSpam,
+			Details:   fmt.Sprintf("invalid %s response", r.label),
+			Score:     score,
+			SpamScore: r.weight,
+			Rejection: &Rejection{
+				Status:  StatusSpam,
+				Reason:  fmt.Sprintf("%s verification failed", r.label),
+				Code:    http.StatusBadRequest,
+				Error:   fmt.Sprintf("%s verification failed", r.label),
+				Details: fmt.Sprintf("Invalid %s response", r.label),
+			},
+		}
+	}
+	serverDebugLog("%s verification successful for IP: %s", r.label, sub.Entry.UserIP)
+	result := CheckResult{Verdict: VerdictPass, Score: score}
+	if score != nil {
+		// Low but passing v3 scores are weak evidence of a bot
+		result.SpamScore = r.weight * (1 - *score)
+		result.Details = fmt.Sprintf("score %.2f", *score)
+	}
+	return result
+}
+
+func (r *captchaChecker) failed(err error, score *float64) CheckResult {
+	return CheckResult{
+		Err:   err,
+		Score: score,
+		Rejection: &Rejection{
+			Status:  StatusRejected,
+			Reason:  fmt.Sprintf("%s verification error: %v", r.label, err),
+			Code:    http.StatusBadRequest,
+			Error:   fmt.Sprintf("%s verification failed", r.label),
+			Details: err.Error(),
+		},
+	}
+}
+
+// verifyCaptcha checks the response with verifier, returning the score when
+// the verifier reports one
+func verifyCaptcha(ctx context.Context, verifier CaptchaVerifier, response, remoteIP string) (bool, *float64, error) {
+	if scored, ok := verifier.(ScoredCaptchaVerifier); ok {
+		valid, score, err := scored.VerifyWithScore(ctx, response, remoteIP)
+		if score == 0 {
+			return valid, nil, err
+		}
+		return valid, &score, err
+	}
+	valid, err := verifier.Verify(ctx, response, remoteIP)
+	return valid, nil, err
+}
+
+// akismetChecker asks Akismet whether the submission is spam
+type akismetChecker struct {
+	client  *AkismetClient
+	comment func(entry *GuestbookEntry) AkismetComment
+	weight  float64
+}
+
+func (*akismetChecker) Name() string { return "akismet" }
+
+func (a *akismetChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	if a.client == nil {
+		serverDebugLog("Akismet client not configured, skipping spam check")
+		return CheckResult{Verdict: VerdictSkipped, Details: "Akismet not configured"}
+	}
+
+	serverDebugLog("Starting Akismet spam check for submission from %s", sub.Request.Name)
+	comment := a.comment(sub.Entry)
+	comment.HoneypotValue = sub.Request.Honeypot
+	isSpam, err := a.client.CheckSpam(ctx, comment)
+	if err != nil {
+		serverDebugLog("Akismet check failed with error: %v", err)
+		return CheckResult{Err: err}
+	}
+	if isSpam {
+		serverDebugLog("Akismet detected spam from %s (IP: %s)", sub.Request.Name, sub.Entry.UserIP)
+		return CheckResult{Verdict: VerdictSpam, SpamScore: a.weight, Rejection: silentSpam("Akismet flagged the comment as spam")}
+	}
+	serverDebugLog("Akismet check passed for %s (IP: %s)", sub.Request.Name, sub.Entry.UserIP)
+	return CheckResult{Verdict: VerdictPass}
+}
+
+// heuristicsChecker weighs the built-in content heuristics
+type heuristicsChecker struct {
+	signals func(req GuestbookRequest) []spamSignal
+	weight  func(signal string) float64
+}
+
+func (heuristicsChecker) Name() string { return "heuristics" }
+
+func (h heuristicsChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	serverDebugLog("Running additional spam heuristics for %s", sub.Request.Name)
+	signals := h.signals(sub.Request)
+	if len(signals) == 0 {
+		return CheckResult{Verdict: VerdictPass}
+	}
+
+	result := CheckResult{Verdict: VerdictSpam}
+	details := make([]string, 0, len(signals))
+	for _, signal := range signals {
+		result.SpamScore += h.weight(signal.Name)
+		details = append(details, signal.Detail)
+	}
+	result.Details = strings.Join(details, ", ")
+	serverDebugLog("Spam heuristics scored %.2f for %s (IP: %s): %s", result.SpamScore, sub.Request.Name, sub.Entry.UserIP, result.Details)
+	return result
+}
+
+// bayesChecker classifies the submission with the model trained from
+// moderation history
+type bayesChecker struct {
+	model  *BayesModel
+	weight float64
+}
+
+func (bayesChecker) Name() string { return "bayes" }
+
+func (b bayesChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	if !b.model.Trained() {
+		return CheckResult{Verdict: VerdictSkipped, Details: "no trained model"}
+	}
+
+	probability := b.model.SpamProbability(sub.Request.Name + " " + sub.Request.Message)
+	result := CheckResult{Verdict: VerdictPass, Score: &probability, Details: fmt.Sprintf("spam probability %.2f", probability)}
+	if probability > 0.5 {
+		// Probabilities from 0.5 (no idea) to 1 (certainly spam) scale the weight
+		result.Verdict = VerdictSpam
+		result.SpamScore = b.weight * (2*probability - 1)
+	}
+	serverDebugLog("Bayes classifier rated submission from %s: %s", sub.Request.Name, result.Details)
+	return result
+}
@@ -0,0 +1,193 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bryankaraffa/b10a.co/guestbook-server/pkg/fakeapi"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scoredVerifier implements ScoredCaptchaVerifier for testing
+type scoredVerifier struct {
+	valid bool
+	score float64
+	err   error
+}
+
+func (v scoredVerifier) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	return v.valid, v.err
+}
+
+func (v scoredVerifier) VerifyWithScore(ctx context.Context, response, remoteIP string) (bool, float64, error) {
+	return v.valid, v.score, v.err
+}
+
+func TestHoneypotChecker(t *testing.T) {
+	sub := newTestSubmission()
+	assert.Equal(t, VerdictPass, honeypotChecker{}.Check(context.Background(), sub).Verdict)
+
+	sub.Request.Honeypot = "http://spam.example"
+	result := honeypotChecker{}.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSpam, result.Verdict)
+	require.NotNil(t, result.Rejection)
+	assert.Empty(t, result.Rejection.Error, "bots are not told they were caught")
+}
+
+func TestRecaptchaChecker(t *testing.T) {
+	sub := newTestSubmission()
+	checker := &captchaChecker{verifier: scoredVerifier{valid: true, score: 0.9}, label: "reCAPTCHA"}
+
+	result := checker.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSpam, result.Verdict, "a missing token is rejected")
+	assert.Equal(t, http.StatusBadRequest, result.Rejection.Code)
+
+	sub.Request.CaptchaResponse = "token"
+	result = checker.Check(context.Background(), sub)
+	assert.Equal(t, VerdictPass, result.Verdict)
+	require.NotNil(t, result.Score)
+	assert.Equal(t, 0.9, *result.Score)
+
+	checker.verifier = scoredVerifier{valid: false, score: 0.1}
+	result = checker.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSpam, result.Verdict)
+	assert.Equal(t, StatusSpam, result.Rejection.Status)
+
+	checker.verifier = scoredVerifier{err: errors.New("connection refused")}
+	result = checker.Check(context.Background(), sub)
+	assert.EqualError(t, result.Err, "connection refused")
+	assert.Nil(t, result.Score, "a zero score is not reported")
+
+	checker.verifier = nil
+	assert.Error(t, checker.Check(context.Background(), sub).Err)
+}
+
+func TestAkismetChecker(t *testing.T) {
+	response := "false"
+	akismet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if response == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	defer akismet.Close()
+
+	client := NewAkismetClient("test-key", "https://example.com", WithBaseURL(akismet.URL))
+	comment := func(entry *GuestbookEntry) AkismetComment { return AkismetComment{CommentContent: entry.Message} }
+	sub := newTestSubmission()
+
+	assert.Equal(t, VerdictSkipped, (&akismetChecker{comment: comment}).Check(context.Background(), sub).Verdict)
+
+	checker := &akismetChecker{client: client, comment: comment}
+	assert.Equal(t, VerdictPass, checker.Check(context.Background(), sub).Verdict)
+
+	response = "true"
+	assert.Equal(t, VerdictSpam, checker.Check(context.Background(), sub).Verdict)
+
+	response = ""
+	assert.Error(t, checker.Check(context.Background(), sub).Err)
+}
+
+func TestHeuristicsChecker(t *testing.T) {
+	server := New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 100, RateLimitWindow: 60})
+	checker := heuristicsChecker{signals: server.spamSignals, weight: server.spamWeight}
+
+	sub := newTestSubmission()
+	sub.Request.Message = "Lovely site"
+	assert.Equal(t, VerdictPass, checker.Check(context.Background(), sub).Verdict)
+
+	sub.Request.Message = "Feel free to reach out"
+	result := checker.Check(context.Background(), sub)
+	assert.InDelta(t, 0.4, result.SpamScore, 1e-9)
+	assert.Less(t, result.SpamScore, server.spamRejectThreshold(), "one keyword alone is not rejected")
+
+	sub.Request.Message = "Free casino bonus, click here [url=http://spam.example]now[/url]"
+	result = checker.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSpam, result.Verdict)
+	assert.GreaterOrEqual(t, result.SpamScore, server.spamRejectThreshold())
+	assert.Contains(t, result.Details, `keyword "casino"`)
+	assert.Contains(t, result.Details, "pattern bbcode_link")
+}
+
+func TestRecaptchaChecker_LowScoreAddsWeight(t *testing.T) {
+	sub := newTestSubmission()
+	sub.Request.CaptchaResponse = "token"
+	checker := &captchaChecker{verifier: scoredVerifier{valid: true, score: 0.6}, label: "reCAPTCHA", weight: 1}
+
+	result := checker.Check(context.Background(), sub)
+	assert.Equal(t, VerdictPass, result.Verdict)
+	assert.InDelta(t, 0.4, result.SpamScore, 1e-9)
+}
+
+func TestSpamPipeline_Configuration(t *testing.T) {
+	server := New(&Config{
+		AllowedOrigins:    []string{"*"},
+		RateLimitRequests: 100,
+		RateLimitWindow:   60,
+		SpamChecks:        []string{"heuristics", "honeypot", "bogus"},
+	})
+
+	pipeline := server.spamPipeline()
+	require.Len(t, pipeline.checkers, 2, "unknown checks are skipped")
+	assert.Equal(t, "heuristics", pipeline.checkers[0].Name())
+	assert.Equal(t, "honeypot", pipeline.checkers[1].Name())
+
+	server.config.SpamChecks = nil
+	pipeline = server.spamPipeline()
+	require.Len(t, pipeline.checkers, len(defaultSpamChecks))
+}
+
+// submitWithCaptcha posts a submission carrying the captcha token to server
+func submitWithCaptcha(server *Server, token string) *httptest.ResponseRecorder {
+	jsonData, _ := json.Marshal(map[string]string{"name": "Test User", "message": "Hello there", "g-recaptcha-response": token})
+	req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRecaptchaChecker_FailurePolicyOnlyCoversOutages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fake := fakeapi.New("b10a.co")
+	fake.SetCaptcha("low-score", fakeapi.CaptchaVerdict{Success: true, Score: 0.1, Action: "submit"})
+	fake.SetCaptcha("wrong-action", fakeapi.CaptchaVerdict{Success: true, Score: 0.9, Action: "login"})
+	fake.SetCaptcha("down", fakeapi.CaptchaVerdict{Status: http.StatusBadGateway})
+	fake.SetCaptcha("replayed", fakeapi.CaptchaVerdict{Success: true, Score: 0.9, Action: "submit", Age: time.Hour})
+	fake.SetCaptcha("foreign", fakeapi.CaptchaVerdict{Success: true, Score: 0.9, Action: "submit", Hostname: "token-farm.example"})
+	api := httptest.NewServer(fake)
+	defer api.Close()
+
+	for _, policy := range []FailurePolicy{FailOpen, FailReview} {
+		server := New(&Config{
+			AllowedOrigins:     []string{"*"},
+			RateLimitRequests:  100,
+			RateLimitWindow:    60,
+			RecaptchaSecretKey: "test-secret",
+			CaptchaVerifyURL:   api.URL + "/recaptcha/api/siteverify",
+			CaptchaHostnames:   []string{"b10a.co"},
+			FailurePolicies:    map[string]FailurePolicy{"recaptcha": policy},
+		})
+		store := &MockEntryStore{}
+		server.store = store
+
+		for _, token := range []string{"fail", "low-score", "wrong-action", "replayed", "foreign"} {
+			rr := submitWithCaptcha(server, token)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, "%s under %s", token, policy)
+		}
+		assert.Empty(t, store.entries, "rejected tokens are not accepted under %s", policy)
+
+		rr := submitWithCaptcha(server, "down")
+		assert.Equal(t, http.StatusOK, rr.Code, "outages follow the %s policy", policy)
+		assert.Len(t, store.entries, 1)
+	}
+}
@@ -0,0 +1,153 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var dashboardTemplate = template.Must(template.New("dashboard.html").Funcs(template.FuncMap{
+	// Entries are stored HTML-escaped; unescape them so the template escapes them exactly once
+	"unescape": html.UnescapeString,
+	"formatDate": func(unix int64) string {
+		return time.Unix(unix, 0).UTC().Format("Jan 2, 2006 15:04 UTC")
+	},
+	"formatScore": func(score *float64) string {
+		if score == nil {
+			return "n/a"
+		}
+		return fmt.Sprintf("%.2f", *score)
+	},
+}).ParseFS(templateFS, "templates/dashboard.html"))
+
+type dashboardData struct {
+	Status    EntryStatus
+	Statuses  []EntryStatus
+	Entries   []*GuestbookEntry
+	CSRFToken string
+	Notice    string
+	Error     string
+}
+
+// dashboardActions maps the dashboard's action buttons to entry statuses
+var dashboardActions = map[string]EntryStatus{
+	"approve": StatusApproved,
+	"reject":  StatusRejected,
+	"spam":    StatusSpam,
+}
+
+// csrfToken protects the dashboard forms. Browsers resend basic auth
+// credentials on cross-site form posts, so the token is derived from the admin
+// token where other sites cannot read it.
+func (s *Server) csrfToken() string {
+	mac := hmac.New(sha256.New, []byte(s.config.AdminToken))
+	mac.Write([]byte("guestbook-dashboard-csrf"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) handleDashboard(c *gin.Context) {
+	data := dashboardData{
+		Status:    StatusPending,
+		Statuses:  entryStatuses,
+		CSRFToken: s.csrfToken(),
+		Notice:    c.Query("notice"),
+		Error:     c.Query("error"),
+	}
+	if param := c.Query("status"); param != "" {
+		status, err := ParseEntryStatus(param)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		data.Status = status
+	}
+
+	store := s.moderationStore()
+	if store == nil {
+		c.String(http.StatusInternalServerError, errNoStore.Error())
+		return
+	}
+	entries, err := store.ListEntries(c.Request.Context(), data.Status)
+	if err != nil {
+		serverDebugLog("Failed to list %s entries for dashboard: %v", data.Status, err)
+		c.String(http.StatusInternalServerError, "Failed to list entries")
+		return
+	}
+	data.Entries = entries
+
+	var buf bytes.Buffer
+	if err := dashboardTemplate.Execute(&buf, data); err != nil {
+		serverDebugLog("Failed to render dashboard: %v", err)
+		c.String(http.StatusInternalServerError, "Failed to render dashboard")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// handleDashboardModerate applies one action to the selected entries. A
+// per-entry button submits "entry=<action>:<id>" instead of the bulk fields.
+func (s *Server) handleDashboardModerate(c *gin.Context) {
+	if !hmac.Equal([]byte(c.PostForm("csrf")), []byte(s.csrfToken())) {
+		c.String(http.StatusForbidden, "Invalid form token")
+		return
+	}
+
+	action, ids := c.PostForm("action"), c.PostFormArray("ids")
+	if single := c.PostForm("entry"); single != "" {
+		var id string
+		action, id, _ = strings.Cut(single, ":")
+		ids = []string{id}
+	}
+
+	query := url.Values{}
+	if status := c.PostForm("status"); status != "" {
+		query.Set("status", status)
+	}
+
+	status, ok := dashboardActions[action]
+	switch {
+	case !ok:
+		query.Set("error", fmt.Sprintf("Unknown action %q", action))
+	case len(ids) == 0:
+		query.Set("error", "No entries selected")
+	default:
+		var failed []string
+		for _, id := range ids {
+			if _, err := s.moderateEntry(c.Request.Context(), id, status, moderationVerb(status)+" by moderator"); err != nil {
+				serverDebugLog("Dashboard failed to set entry %s to %s: %v", id, status, err)
+				failed = append(failed, id)
+			}
+		}
+		if done := len(ids) - len(failed); done > 0 {
+			query.Set("notice", fmt.Sprintf("%d %s %s", done, pluralize(done, "entry", "entries"), moderationVerb(status)))
+		}
+		if len(failed) > 0 {
+			query.Set("error", fmt.Sprintf("Failed to update %s", strings.Join(failed, ", ")))
+		}
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/?"+query.Encode())
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
@@ -0,0 +1,113 @@
+package guestbook_server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dashboardPost(t *testing.T, server *Server, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest("POST", "/admin/moderate", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("moderator", "secret-token")
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestDashboard_RendersQueue(t *testing.T) {
+	server, _, records := newAdminTestServer(t)
+	score := 0.9
+	records.entries[0].UserIP = "192.0.2.1"
+	records.entries[0].UserAgent = "Mozilla/5.0 (iPhone)"
+	records.entries[0].Message = "Hi &amp; bye"
+	records.entries[0].Checks = []SpamCheckResult{
+		{Check: "recaptcha", Verdict: VerdictPass, Score: &score},
+		{Check: "akismet", Verdict: VerdictError, Details: "akismet API returned status 500"},
+		{Check: "heuristics", Verdict: VerdictPass},
+	}
+
+	req, err := http.NewRequest("GET", "/admin/", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("moderator", "secret-token")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "Jane")
+	assert.Contains(t, body, "Hi &amp; bye", "stored entries must be escaped exactly once")
+	assert.Contains(t, body, "192.0.2.1")
+	assert.Contains(t, body, "Mozilla/5.0 (iPhone)")
+	assert.Contains(t, body, "0.90")
+	assert.Contains(t, body, "akismet API returned status 500")
+	assert.Contains(t, body, server.csrfToken())
+	assert.NotContains(t, body, "casino", "only pending entries are listed by default")
+}
+
+func TestDashboard_PromptsForBasicAuth(t *testing.T) {
+	server, _, _ := newAdminTestServer(t)
+
+	req, err := http.NewRequest("GET", "/admin/", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Basic")
+}
+
+func TestDashboard_BulkModerate(t *testing.T) {
+	server, store, records := newAdminTestServer(t)
+	records.entries = append(records.entries, &GuestbookEntry{ID: "pending-2", Name: "Joe", Status: StatusPending})
+
+	rr := dashboardPost(t, server, url.Values{
+		"csrf":   {server.csrfToken()},
+		"status": {"pending"},
+		"action": {"approve"},
+		"ids":    {"pending-1", "pending-2"},
+	})
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Contains(t, rr.Header().Get("Location"), "notice=2+entries+approved")
+	assert.Len(t, store.entries, 2)
+
+	pending, err := records.ListEntries(context.Background(), StatusPending)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestDashboard_SingleEntryAction(t *testing.T) {
+	server, store, records := newAdminTestServer(t)
+
+	rr := dashboardPost(t, server, url.Values{
+		"csrf":  {server.csrfToken()},
+		"entry": {"spam:pending-1"},
+		"ids":   {"spam-1"},
+	})
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Empty(t, store.entries)
+
+	entry, err := records.GetEntry(context.Background(), "pending-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusSpam, entry.Status)
+}
+
+func TestDashboard_RejectsMissingCSRFToken(t *testing.T) {
+	server, store, _ := newAdminTestServer(t)
+
+	rr := dashboardPost(t, server, url.Values{
+		"action": {"approve"},
+		"ids":    {"pending-1"},
+	})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Empty(t, store.entries)
+}
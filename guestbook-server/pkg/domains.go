@@ -0,0 +1,186 @@
+package guestbook_server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultShorteners are URL shortening services, which hide where a link
+// really goes
+var defaultShorteners = []string{
+	"bit.ly", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "ow.ly", "rb.gy",
+	"rebrand.ly", "shorturl.at", "t.co", "t.ly", "tiny.cc", "tinyurl.com", "v.gd",
+}
+
+// linkHostPattern finds the host of links with a scheme, such as
+// "https://example.com/path" or "[url=http://example.com]", and of bare
+// "www." links
+var linkHostPattern = regexp.MustCompile(`(?i)(?:\b(?:https?|ftp)://(?:[^\s/?#@<>"'\[\]]*@)?|\bwww\.)([^\s/?#:<>"'\[\]]+)`)
+
+// linkHosts returns the distinct lowercase hosts linked from text, in order
+func linkHosts(text string) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, match := range linkHostPattern.FindAllStringSubmatch(text, -1) {
+		host := strings.TrimRight(strings.ToLower(match[1]), ".,;!)")
+		if strings.HasPrefix(strings.ToLower(match[0]), "www.") {
+			host = "www." + host
+		}
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// isPunycode reports whether host is an internationalized domain name, either
+// encoded ("xn--") or as typed. These are often lookalikes of real domains.
+func isPunycode(host string) bool {
+	for _, label := range strings.Split(host, ".") {
+		if strings.HasPrefix(label, "xn--") {
+			return true
+		}
+	}
+	for _, r := range host {
+		if r > 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// domainSet is a set of domains matching themselves and their subdomains
+type domainSet map[string]bool
+
+func newDomainSet(domains []string) domainSet {
+	set := make(domainSet, len(domains))
+	for _, domain := range domains {
+		if domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), "."); domain != "" {
+			set[domain] = true
+		}
+	}
+	return set
+}
+
+// Match returns the listed domain that host is or is a subdomain of, or ""
+func (d domainSet) Match(host string) string {
+	if len(d) == 0 || net.ParseIP(host) != nil {
+		return ""
+	}
+	for domain := host; ; {
+		if d[domain] {
+			return domain
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return ""
+		}
+		domain = parent
+	}
+}
+
+// matchDomain returns the first domain in sets that host is or is a
+// subdomain of, or ""
+func matchDomain(sets []domainSet, host string) string {
+	for _, set := range sets {
+		if domain := set.Match(host); domain != "" {
+			return domain
+		}
+	}
+	return ""
+}
+
+// loadDomainList reads a domain blocklist with one domain per line. Blank
+// lines and "#" comments are ignored, and hosts-file lines such as
+// "0.0.0.0 example.com" are accepted, so most published DNS blocklists can be
+// used as downloaded.
+func loadDomainList(path string) (domainSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read domain list: %w", err)
+	}
+	defer file.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		domains = append(domains, fields[len(fields)-1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domain list %s: %w", path, err)
+	}
+	return newDomainSet(domains), nil
+}
+
+// domainChecker checks the domains a submission links to. Links to blocked
+// domains reject it, and links to domains that are not allowed hold it for
+// review, with URL shorteners and punycode domains also adding their weights.
+type domainChecker struct {
+	allowed    []domainSet // The site's own domains and the rules' allowed domains
+	blocked    []domainSet // The rules' blocked domains and the blocklist file
+	shorteners domainSet
+	weight     func(signal string) float64
+}
+
+func (domainChecker) Name() string { return "domains" }
+
+func (d domainChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	hosts := linkHosts(sub.Request.Name + " " + sub.Request.Message)
+	if len(hosts) == 0 {
+		return CheckResult{Verdict: VerdictPass}
+	}
+
+	for _, host := range hosts {
+		if domain := matchDomain(d.blocked, host); domain != "" {
+			serverDebugLog("Submission from %s links to blocked domain %s", sub.Entry.UserIP, host)
+			return CheckResult{
+				Verdict:   VerdictSpam,
+				Details:   fmt.Sprintf("blocked domain %s", host),
+				Decisive:  true,
+				Rejection: silentSpam(fmt.Sprintf("link to blocked domain %s", domain)),
+			}
+		}
+	}
+
+	var details, unknown []string
+	result := CheckResult{Verdict: VerdictPass}
+	for _, host := range hosts {
+		if matchDomain(d.allowed, host) != "" {
+			continue
+		}
+		unknown = append(unknown, host)
+		if d.shorteners.Match(host) != "" {
+			result.SpamScore += d.weight("shortener")
+			details = append(details, fmt.Sprintf("URL shortener %s", host))
+		}
+		if isPunycode(host) {
+			result.SpamScore += d.weight("punycode")
+			details = append(details, fmt.Sprintf("punycode domain %s", host))
+		}
+	}
+	if len(unknown) == 0 {
+		return result
+	}
+
+	result.Review = true
+	result.Verdict = VerdictReview
+	if result.SpamScore > 0 {
+		result.Verdict = VerdictSpam
+	}
+	details = append(details, fmt.Sprintf("unknown %s %s", pluralize(len(unknown), "domain", "domains"), strings.Join(unknown, ", ")))
+	result.Details = strings.Join(details, ", ")
+	serverDebugLog("Holding submission from %s for review: %s", sub.Entry.UserIP, result.Details)
+	return result
+}
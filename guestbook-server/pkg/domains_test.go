@@ -0,0 +1,116 @@
+package guestbook_server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkHosts(t *testing.T) {
+	text := "See https://Example.com/page, [url=http://spam.example.net]this[/url], " +
+		"ftp://user@files.example.org:21/x and www.shop.example. Also https://example.com again."
+	assert.Equal(t, []string{"example.com", "spam.example.net", "files.example.org", "www.shop.example"}, linkHosts(text))
+	assert.Empty(t, linkHosts("No links here, just example dot com"))
+}
+
+func TestIsPunycode(t *testing.T) {
+	assert.True(t, isPunycode("xn--pple-43d.com"))
+	assert.True(t, isPunycode("аpple.com"), "Cyrillic a")
+	assert.False(t, isPunycode("apple.com"))
+}
+
+func TestDomainSet_MatchesSubdomains(t *testing.T) {
+	set := newDomainSet([]string{"Example.com.", " bit.ly "})
+	assert.Equal(t, "example.com", set.Match("example.com"))
+	assert.Equal(t, "example.com", set.Match("www.shop.example.com"))
+	assert.Equal(t, "bit.ly", set.Match("bit.ly"))
+	assert.Empty(t, set.Match("notexample.com"))
+	assert.Empty(t, set.Match("com"))
+}
+
+func TestLoadDomainList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte(`# Blocked domains
+spam.example
+
+0.0.0.0 casino.example  # hosts file format
+127.0.0.1	pills.example
+`), 0644))
+
+	set, err := loadDomainList(path)
+	require.NoError(t, err)
+	assert.Len(t, set, 3)
+	assert.NotEmpty(t, set.Match("www.casino.example"))
+	assert.NotEmpty(t, set.Match("pills.example"))
+
+	_, err = loadDomainList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestDomainChecker(t *testing.T) {
+	checker := domainChecker{
+		allowed:    []domainSet{newDomainSet([]string{"b10a.co"}), newDomainSet([]string{"github.com"})},
+		blocked:    []domainSet{newDomainSet([]string{"casino.example"}), nil},
+		shorteners: newDomainSet(defaultShorteners),
+		weight:     func(string) float64 { return 0.5 },
+	}
+	check := func(message string) CheckResult {
+		sub := newTestSubmission()
+		sub.Request.Message = message
+		return checker.Check(context.Background(), sub)
+	}
+
+	assert.Equal(t, CheckResult{Verdict: VerdictPass}, check("No links"))
+	assert.Equal(t, CheckResult{Verdict: VerdictPass}, check("Loved https://b10a.co/posts/1 and https://github.com/bryankaraffa"))
+
+	result := check("Play at https://www.casino.example/ now")
+	assert.True(t, result.Decisive)
+	require.NotNil(t, result.Rejection)
+	assert.Equal(t, StatusSpam, result.Rejection.Status)
+	assert.Equal(t, http.StatusOK, result.Rejection.Code)
+	assert.Equal(t, "link to blocked domain casino.example", result.Rejection.Reason)
+
+	result = check("My blog is at https://jane.example.org")
+	assert.True(t, result.Review)
+	assert.Equal(t, VerdictReview, result.Verdict)
+	assert.Zero(t, result.SpamScore)
+	assert.Equal(t, "unknown domain jane.example.org", result.Details)
+
+	result = check("Look https://bit.ly/abc and https://xn--pple-43d.com")
+	assert.True(t, result.Review)
+	assert.Equal(t, VerdictSpam, result.Verdict)
+	assert.Equal(t, 1.0, result.SpamScore)
+	assert.Contains(t, result.Details, "URL shortener bit.ly")
+	assert.Contains(t, result.Details, "punycode domain xn--pple-43d.com")
+}
+
+func TestDomainChecker_UsesRulesAndBlocklistFile(t *testing.T) {
+	dir := t.TempDir()
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	require.NoError(t, os.WriteFile(rulesPath, []byte("allowed_domains: [friends.example]\nblocked_domains: [spam.example]\n"), 0644))
+	blocklistPath := filepath.Join(dir, "blocklist.txt")
+	require.NoError(t, os.WriteFile(blocklistPath, []byte("0.0.0.0 pills.example\n"), 0644))
+
+	server := New(&Config{
+		Port:                   "8080",
+		AllowedRedirectDomains: []string{"b10a.co"},
+		SpamRulesPath:          rulesPath,
+		DomainBlocklistPath:    blocklistPath,
+	})
+	checker := server.spamCheckers()["domains"]
+	check := func(message string) CheckResult {
+		sub := newTestSubmission()
+		sub.Request.Message = message
+		return checker.Check(context.Background(), sub)
+	}
+
+	assert.Equal(t, VerdictPass, check("https://b10a.co and https://www.friends.example").Verdict)
+	assert.True(t, check("https://spam.example").Decisive)
+	assert.True(t, check("https://pills.example").Decisive)
+	assert.True(t, check("https://other.example").Review)
+}
@@ -0,0 +1,257 @@
+package guestbook_server
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultDuplicateWindow is how long accepted submissions are remembered
+	defaultDuplicateWindow = time.Hour
+	// duplicateDistance is the largest SimHash Hamming distance at which two
+	// messages count as near-duplicates
+	duplicateDistance = 3
+	// duplicateMinWords is the shortest message compared across IPs; short
+	// messages such as "Cool site!" are often sent by different people
+	duplicateMinWords = 5
+	// maxRememberedSubmissions bounds the memory used by the window
+	maxRememberedSubmissions = 1000
+)
+
+// simHash fingerprints text so that lightly varied copies differ in only a
+// few bits. It hashes overlapping three-word shingles of the normalized text.
+func simHash(words []string) uint64 {
+	const shingle = 3
+	var weights [64]int
+	add := func(s string) {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	if len(words) < shingle {
+		add(strings.Join(words, " "))
+	}
+	for i := 0; i+shingle <= len(words); i++ {
+		add(strings.Join(words[i:i+shingle], " "))
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+// submissionWords are the normalized words a submission is fingerprinted by.
+// The message is used rather than the name, which spam waves vary.
+func submissionWords(req GuestbookRequest) []string {
+	if words := wordTokens(normalizeText(req.Message)); len(words) > 0 {
+		return words
+	}
+	return wordTokens(normalizeText(req.Name))
+}
+
+// submissionName is the normalized name a submission was sent under
+func submissionName(req GuestbookRequest) string {
+	return strings.Join(wordTokens(normalizeText(req.Name)), " ")
+}
+
+// rememberedSubmission is a fingerprint of a recently accepted submission
+type rememberedSubmission struct {
+	fingerprint uint64
+	words       int
+	name        string
+	ip          string
+	entryID     string
+	at          time.Time
+}
+
+// repeatedBy reports whether a near-duplicate sent from ip under name is the
+// same person sending it again, such as a double-clicked submit button.
+// Different people behind one NAT often send the same short message.
+func (r *rememberedSubmission) repeatedBy(ip, name string) bool {
+	return r.ip == ip && r.name == name
+}
+
+// duplicateTracker keeps a rolling window of recently accepted submissions
+type duplicateTracker struct {
+	mu      sync.Mutex
+	window  time.Duration
+	recent  []rememberedSubmission
+	nowFunc func() time.Time
+}
+
+func newDuplicateTracker(window time.Duration) *duplicateTracker {
+	if window <= 0 {
+		window = defaultDuplicateWindow
+	}
+	return &duplicateTracker{window: window, nowFunc: time.Now}
+}
+
+// prune drops submissions older than the window. Callers hold mu.
+func (d *duplicateTracker) prune(now time.Time) {
+	cutoff := now.Add(-d.window)
+	i := 0
+	for i < len(d.recent) && d.recent[i].at.Before(cutoff) {
+		i++
+	}
+	d.recent = d.recent[i:]
+}
+
+// Remember adds an accepted submission to the window, unless Reserve
+// already added it
+func (d *duplicateTracker) Remember(req GuestbookRequest, ip, entryID string) {
+	words := submissionWords(req)
+	if len(words) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(d.nowFunc())
+	if d.find(entryID) < 0 {
+		d.add(words, submissionName(req), ip, entryID)
+	}
+}
+
+// Reserve returns the most recent remembered submission that req nearly
+// duplicates, or nil, and in the same step remembers req unless it repeats
+// a submission from the same IP under the same name. Doing both under one lock means two
+// concurrent copies of a submission cannot both miss each other. Callers
+// Forget the entry again if it is not stored after all.
+func (d *duplicateTracker) Reserve(req GuestbookRequest, ip, entryID string) *rememberedSubmission {
+	words := submissionWords(req)
+	if len(words) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(d.nowFunc())
+	name := submissionName(req)
+	match := d.match(simHash(words))
+	if match == nil || !match.repeatedBy(ip, name) {
+		d.add(words, name, ip, entryID)
+	}
+	return match
+}
+
+// Match returns the most recent remembered submission that req nearly
+// duplicates, or nil
+func (d *duplicateTracker) Match(req GuestbookRequest) *rememberedSubmission {
+	words := submissionWords(req)
+	if len(words) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(d.nowFunc())
+	return d.match(simHash(words))
+}
+
+// Forget drops a reserved submission that was rejected or failed to store
+func (d *duplicateTracker) Forget(entryID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if i := d.find(entryID); i >= 0 {
+		d.recent = append(d.recent[:i], d.recent[i+1:]...)
+	}
+}
+
+// match finds the most recent near-duplicate of fingerprint. Callers hold mu.
+func (d *duplicateTracker) match(fingerprint uint64) *rememberedSubmission {
+	for i := len(d.recent) - 1; i >= 0; i-- {
+		if bits.OnesCount64(d.recent[i].fingerprint^fingerprint) <= duplicateDistance {
+			match := d.recent[i]
+			return &match
+		}
+	}
+	return nil
+}
+
+// find returns the index of the entry's submission, or -1. Callers hold mu.
+func (d *duplicateTracker) find(entryID string) int {
+	for i := range d.recent {
+		if d.recent[i].entryID == entryID {
+			return i
+		}
+	}
+	return -1
+}
+
+// add appends a submission to the window. Callers hold mu.
+func (d *duplicateTracker) add(words []string, name, ip, entryID string) {
+	if len(d.recent) >= maxRememberedSubmissions {
+		d.recent = d.recent[1:]
+	}
+	d.recent = append(d.recent, rememberedSubmission{
+		fingerprint: simHash(words),
+		words:       len(words),
+		name:        name,
+		ip:          ip,
+		entryID:     entryID,
+		at:          d.nowFunc(),
+	})
+}
+
+// duplicateChecker catches resubmissions: a repeat from the same IP under the
+// same name, such as a double-clicked submit button, is dropped quietly, while
+// a spam wave sending near-identical messages from other IPs or names adds the
+// "duplicate" weight
+type duplicateChecker struct {
+	tracker *duplicateTracker
+	weight  float64
+}
+
+func (duplicateChecker) Name() string { return "duplicate" }
+
+func (d duplicateChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	if d.tracker == nil {
+		return CheckResult{Verdict: VerdictSkipped}
+	}
+	match := d.tracker.Reserve(sub.Request, sub.Entry.UserIP, sub.Entry.ID)
+	if match == nil {
+		return CheckResult{Verdict: VerdictPass}
+	}
+
+	if match.repeatedBy(sub.Entry.UserIP, submissionName(sub.Request)) {
+		serverDebugLog("Submission from %s repeats entry %s, dropping it", sub.Entry.UserIP, match.entryID)
+		reason := fmt.Sprintf("repeat of entry %s from the same IP", match.entryID)
+		return CheckResult{
+			Verdict:  VerdictSpam,
+			Details:  reason,
+			Decisive: true,
+			// Tell the client it worked, since the first submission did
+			Rejection: &Rejection{Status: StatusRejected, Reason: reason, Code: http.StatusOK},
+		}
+	}
+	if match.words < duplicateMinWords {
+		return CheckResult{Verdict: VerdictPass}
+	}
+	source := "another IP"
+	if match.ip == sub.Entry.UserIP {
+		source = "the same IP under another name"
+	}
+	serverDebugLog("Submission from %s nearly duplicates entry %s from %s", sub.Entry.UserIP, match.entryID, match.ip)
+	return CheckResult{
+		Verdict:   VerdictSpam,
+		Details:   fmt.Sprintf("near-duplicate of entry %s from %s", match.entryID, source),
+		SpamScore: d.weight,
+	}
+}
@@ -0,0 +1,188 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const waveMessage = "Hello friend, we offer the best search engine optimization services for your website at very low prices, contact us today"
+
+func TestSimHash_NearDuplicates(t *testing.T) {
+	words := func(s string) []string { return wordTokens(normalizeText(s)) }
+	distance := func(a, b string) int { return bits.OnesCount64(simHash(words(a)) ^ simHash(words(b))) }
+
+	assert.Zero(t, distance(waveMessage, waveMessage))
+	assert.Zero(t, distance(waveMessage, "HELLO   friend, we offer the best search engine optimization services for your website at very low prices, contact us today!!"))
+	assert.LessOrEqual(t, distance(waveMessage, waveMessage+" now"), duplicateDistance)
+	assert.Greater(t, distance(waveMessage, "Thanks for the write-up on your home lab, it helped me set up my own Raspberry Pi cluster"), duplicateDistance)
+}
+
+func TestDuplicateTracker_Window(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newDuplicateTracker(time.Hour)
+	tracker.nowFunc = func() time.Time { return now }
+
+	req := GuestbookRequest{Name: "Jane", Message: "Lovely site"}
+	assert.Nil(t, tracker.Match(req))
+
+	tracker.Remember(req, "192.0.2.1", "entry-1")
+	match := tracker.Match(req)
+	require.NotNil(t, match)
+	assert.Equal(t, "entry-1", match.entryID)
+
+	now = now.Add(61 * time.Minute)
+	assert.Nil(t, tracker.Match(req), "submissions are forgotten after the window")
+}
+
+func TestDuplicateChecker(t *testing.T) {
+	tracker := newDuplicateTracker(time.Hour)
+	checker := duplicateChecker{tracker: tracker, weight: 1}
+	tracker.Remember(GuestbookRequest{Message: waveMessage}, "192.0.2.1", "entry-1")
+	tracker.Remember(GuestbookRequest{Name: "Jane", Message: "Cool site!"}, "192.0.2.1", "entry-2")
+
+	check := func(ip, message string) CheckResult {
+		sub := newTestSubmission()
+		sub.Entry.UserIP = ip
+		sub.Request.Message = message
+		return checker.Check(context.Background(), sub)
+	}
+
+	// A double-clicked submit button is dropped without telling the user
+	result := check("192.0.2.1", "Cool site!")
+	assert.True(t, result.Decisive)
+	require.NotNil(t, result.Rejection)
+	assert.Equal(t, StatusRejected, result.Rejection.Status)
+	assert.Equal(t, http.StatusOK, result.Rejection.Code)
+	assert.Empty(t, result.Rejection.Error)
+
+	// A spam wave from other IPs adds the duplicate weight
+	result = check("198.51.100.7", waveMessage+" now")
+	assert.False(t, result.Decisive)
+	assert.Equal(t, 1.0, result.SpamScore)
+	assert.Contains(t, result.Details, "entry-1")
+
+	// Different people may well send the same short message
+	assert.Equal(t, VerdictPass, check("198.51.100.7", "Cool site!").Verdict)
+	assert.Equal(t, VerdictPass, check("198.51.100.7", "Something else entirely").Verdict)
+}
+
+func TestDuplicateChecker_SharedIP(t *testing.T) {
+	tracker := newDuplicateTracker(time.Hour)
+	checker := duplicateChecker{tracker: tracker, weight: 1}
+	check := func(name, message string) CheckResult {
+		sub := newTestSubmission()
+		sub.Entry.ID = generateID()
+		sub.Entry.UserIP = "192.0.2.1"
+		sub.Request.Name = name
+		sub.Request.Message = message
+		return checker.Check(context.Background(), sub)
+	}
+
+	// Two people behind one NAT both post a short message
+	assert.Equal(t, VerdictPass, check("Jane", "Great site!").Verdict)
+	assert.Equal(t, VerdictPass, check("Bob", "Great site!").Verdict)
+	assert.True(t, check("bob", "Great site!").Decisive, "the same person submitting twice is still caught")
+
+	// Longer copies under another name from the same IP look like a spam wave
+	assert.Equal(t, VerdictPass, check("Jane", waveMessage).Verdict)
+	result := check("Alex", waveMessage)
+	assert.False(t, result.Decisive)
+	assert.Equal(t, 1.0, result.SpamScore)
+	assert.Contains(t, result.Details, "under another name")
+}
+
+func TestGuestbookSubmission_DoubleSubmit(t *testing.T) {
+	server, store, _ := newAdminTestServer(t)
+	server.records = nil
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+
+	for i := 0; i < 2; i++ {
+		jsonData, _ := json.Marshal(map[string]string{
+			"name":                 "Test User",
+			"message":              "Lovely site, thanks for sharing",
+			"g-recaptcha-response": "mock-response",
+		})
+		req, err := http.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	assert.Len(t, store.entries, 1, "a double-clicked submit must not open two pull requests")
+}
+
+func TestDuplicateTracker_Reserve(t *testing.T) {
+	tracker := newDuplicateTracker(time.Hour)
+	req := GuestbookRequest{Message: waveMessage}
+
+	assert.Nil(t, tracker.Reserve(req, "192.0.2.1", "entry-1"))
+	match := tracker.Reserve(req, "192.0.2.1", "entry-2")
+	require.NotNil(t, match, "the reservation is visible straight away")
+	assert.Equal(t, "entry-1", match.entryID)
+
+	// Remembering a reserved entry does not add it twice
+	tracker.Remember(req, "192.0.2.1", "entry-1")
+	tracker.Forget("entry-1")
+	assert.Nil(t, tracker.Match(req), "rejected or unstored submissions are released")
+}
+
+// gatedStore blocks CreateEntry until release is closed
+type gatedStore struct {
+	MockEntryStore
+	mu      sync.Mutex
+	release chan struct{}
+}
+
+func (g *gatedStore) CreateEntry(ctx context.Context, entry *GuestbookEntry) error {
+	<-g.release
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.MockEntryStore.CreateEntry(ctx, entry)
+}
+
+func TestGuestbookSubmission_ConcurrentDoubleSubmit(t *testing.T) {
+	server, _, _ := newAdminTestServer(t)
+	server.records = nil
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+	store := &gatedStore{release: make(chan struct{})}
+	server.store = store
+
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			jsonData, _ := json.Marshal(map[string]string{
+				"name":                 "Test User",
+				"message":              "Lovely site, thanks for sharing",
+				"g-recaptcha-response": "mock-response",
+			})
+			req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, req)
+			done <- rr.Code
+		}()
+	}
+
+	// The copy is dropped while the first submission is still being stored
+	select {
+	case code := <-done:
+		assert.Equal(t, http.StatusOK, code)
+	case <-time.After(2 * time.Second):
+		t.Error("both submissions reached the store")
+	}
+	close(store.release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Len(t, store.entries, 1, "concurrent copies must not open two pull requests")
+}
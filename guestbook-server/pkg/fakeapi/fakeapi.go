@@ -0,0 +1,164 @@
+// Package fakeapi is a stand-in for the Akismet REST API and the reCAPTCHA,
+// hCaptcha and Turnstile siteverify endpoints. It returns scripted verdicts,
+// so tests and local development can exercise the real clients without
+// network access or API keys.
+//
+// Point the guestbook server at it with AKISMET_ENDPOINT=<url>/1.1 and
+// CAPTCHA_VERIFY_URL=<url>/siteverify.
+package fakeapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SpamAuthor is always flagged as spam, like Akismet's own test author
+	SpamAuthor = "viagra-test-123"
+	// akismetThanks is Akismet's response to spam and ham reports
+	akismetThanks = "Thanks for making the web a better place."
+)
+
+// CaptchaVerdict is the siteverify response scripted for a token
+type CaptchaVerdict struct {
+	Success    bool
+	Score      float64 // reCAPTCHA v3 score; omitted when zero
+	Action     string
+	Hostname   string        // The server's hostname when empty
+	Age        time.Duration // How long ago the challenge was solved
+	ErrorCodes []string
+	Status     int // HTTP status, 200 when zero
+}
+
+// Pass returns a successful verdict with a v3 score of 0.9
+func Pass() CaptchaVerdict {
+	return CaptchaVerdict{Success: true, Score: 0.9, Action: "submit"}
+}
+
+// Fail returns the verdict for an invalid or expired token
+func Fail() CaptchaVerdict {
+	return CaptchaVerdict{ErrorCodes: []string{"invalid-input-response"}}
+}
+
+// AkismetVerdict is the comment-check response scripted for comments
+// containing a phrase
+type AkismetVerdict struct {
+	Spam      bool
+	DebugHelp string // Sent as X-akismet-debug-help
+	Status    int    // HTTP status, 200 when zero
+}
+
+type akismetRule struct {
+	phrase  string
+	verdict AkismetVerdict
+}
+
+// Request is a request the server received
+type Request struct {
+	Path string
+	Form url.Values
+}
+
+// Server is an http.Handler serving the fake APIs. Its zero value is not
+// usable; create one with New.
+type Server struct {
+	mu             sync.Mutex
+	hostname       string
+	secret         string
+	captcha        map[string]CaptchaVerdict
+	defaultCaptcha CaptchaVerdict
+	keys           map[string]bool
+	akismet        []akismetRule
+	requests       []Request
+}
+
+// New creates a server that accepts any captcha token and API key, treats
+// comments as ham unless their author is SpamAuthor, and reports tokens as
+// minted for hostname
+func New(hostname string) *Server {
+	return &Server{
+		hostname: hostname,
+		captcha: map[string]CaptchaVerdict{
+			"pass": Pass(),
+			"fail": Fail(),
+		},
+		defaultCaptcha: Pass(),
+		keys:           make(map[string]bool),
+	}
+}
+
+// SetCaptcha scripts the verdict for a captcha token
+func (s *Server) SetCaptcha(token string, verdict CaptchaVerdict) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.captcha[token] = verdict
+}
+
+// SetDefaultCaptcha scripts the verdict for tokens without their own
+func (s *Server) SetDefaultCaptcha(verdict CaptchaVerdict) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultCaptcha = verdict
+}
+
+// RequireSecret makes siteverify reject requests with another secret
+func (s *Server) RequireSecret(secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secret = secret
+}
+
+// SetAkismetKeys limits the API keys verify-key accepts; any key is valid
+// until this is called
+func (s *Server) SetAkismetKeys(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		s.keys[key] = true
+	}
+}
+
+// SetAkismet scripts the comment-check verdict for comments whose author or
+// content contains phrase. Earlier rules win.
+func (s *Server) SetAkismet(phrase string, verdict AkismetVerdict) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.akismet = append(s.akismet, akismetRule{phrase: phrase, verdict: verdict})
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Path: r.URL.Path, Form: r.PostForm})
+	s.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/siteverify"):
+		s.siteverify(w, r)
+	case strings.HasSuffix(r.URL.Path, "/verify-key"):
+		s.verifyKey(w, r)
+	case strings.HasSuffix(r.URL.Path, "/comment-check"):
+		s.commentCheck(w, r)
+	case strings.HasSuffix(r.URL.Path, "/submit-spam"), strings.HasSuffix(r.URL.Path, "/submit-ham"):
+		w.Write([]byte(akismetThanks))
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,109 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func post(t *testing.T, server *httptest.Server, path string, form url.Values) *http.Response {
+	t.Helper()
+	resp, err := http.PostForm(server.URL+path, form)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func body(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func siteverify(t *testing.T, server *httptest.Server, secret, token string) siteverifyResponse {
+	t.Helper()
+	resp := post(t, server, "/recaptcha/api/siteverify", url.Values{"secret": {secret}, "response": {token}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var result siteverifyResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	return result
+}
+
+func TestSiteverify(t *testing.T) {
+	fake := New("b10a.co")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	result := siteverify(t, server, "secret", "anything")
+	assert.True(t, result.Success, "unscripted tokens pass by default")
+	assert.Equal(t, 0.9, result.Score)
+	assert.Equal(t, "b10a.co", result.Hostname)
+	solved, err := time.Parse(time.RFC3339, result.ChallengeTS)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), solved, 5*time.Second)
+
+	result = siteverify(t, server, "secret", "fail")
+	assert.False(t, result.Success)
+	assert.Equal(t, []string{"invalid-input-response"}, result.ErrorCodes)
+
+	fake.SetCaptcha("bot", CaptchaVerdict{Success: true, Score: 0.1, Action: "login", Hostname: "elsewhere.example", Age: time.Hour})
+	result = siteverify(t, server, "secret", "bot")
+	assert.Equal(t, 0.1, result.Score)
+	assert.Equal(t, "login", result.Action)
+	assert.Equal(t, "elsewhere.example", result.Hostname)
+
+	fake.SetDefaultCaptcha(Fail())
+	assert.False(t, siteverify(t, server, "secret", "anything").Success)
+
+	fake.RequireSecret("right")
+	assert.Equal(t, []string{"invalid-input-secret"}, siteverify(t, server, "wrong", "pass").ErrorCodes)
+	assert.True(t, siteverify(t, server, "right", "pass").Success)
+
+	fake.SetCaptcha("down", CaptchaVerdict{Status: http.StatusServiceUnavailable})
+	resp := post(t, server, "/siteverify", url.Values{"secret": {"right"}, "response": {"down"}})
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestAkismet(t *testing.T) {
+	fake := New("b10a.co")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	check := func(author, content string) *http.Response {
+		return post(t, server, "/1.1/comment-check", url.Values{"blog": {"https://b10a.co"}, "comment_author": {author}, "comment_content": {content}})
+	}
+	assert.Equal(t, "false", body(t, check("Joe", "Hello")))
+	assert.Equal(t, "true", body(t, check(SpamAuthor, "Hello")))
+
+	fake.SetAkismet("casino", AkismetVerdict{Spam: true, DebugHelp: "scripted"})
+	fake.SetAkismet("outage", AkismetVerdict{Status: http.StatusInternalServerError})
+	resp := check("Joe", "Free casino bonus")
+	assert.Equal(t, "scripted", resp.Header.Get("X-akismet-debug-help"))
+	assert.Equal(t, "true", body(t, resp))
+	assert.Equal(t, http.StatusInternalServerError, check("Joe", "outage").StatusCode)
+
+	assert.Equal(t, "valid", body(t, post(t, server, "/1.1/verify-key", url.Values{"key": {"any"}, "blog": {"https://b10a.co"}})))
+	fake.SetAkismetKeys("good")
+	assert.Equal(t, "invalid", body(t, post(t, server, "/1.1/verify-key", url.Values{"key": {"bad"}, "blog": {"https://b10a.co"}})))
+	assert.Equal(t, "valid", body(t, post(t, server, "/1.1/verify-key", url.Values{"key": {"good"}, "blog": {"https://b10a.co"}})))
+
+	assert.Equal(t, akismetThanks, body(t, post(t, server, "/1.1/submit-spam", url.Values{"comment_content": {"spam"}})))
+
+	requests := fake.Requests()
+	require.Len(t, requests, 8)
+	assert.Equal(t, "/1.1/submit-spam", requests[7].Path)
+	assert.Equal(t, "spam", requests[7].Form.Get("comment_content"))
+
+	resp, err := http.Get(server.URL + "/1.1/comment-check")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,97 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// siteverifyResponse covers the fields of the reCAPTCHA, hCaptcha and
+// Turnstile responses
+type siteverifyResponse struct {
+	Success     bool     `json:"success"`
+	Score       float64  `json:"score,omitempty"`
+	Action      string   `json:"action,omitempty"`
+	ChallengeTS string   `json:"challenge_ts,omitempty"`
+	Hostname    string   `json:"hostname,omitempty"`
+	ErrorCodes  []string `json:"error-codes,omitempty"`
+}
+
+func (s *Server) siteverify(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	secret := s.secret
+	verdict, ok := s.captcha[r.PostForm.Get("response")]
+	if !ok {
+		verdict = s.defaultCaptcha
+	}
+	hostname := s.hostname
+	s.mu.Unlock()
+
+	switch {
+	case r.PostForm.Get("secret") == "":
+		verdict = CaptchaVerdict{ErrorCodes: []string{"missing-input-secret"}}
+	case secret != "" && r.PostForm.Get("secret") != secret:
+		verdict = CaptchaVerdict{ErrorCodes: []string{"invalid-input-secret"}}
+	case r.PostForm.Get("response") == "":
+		verdict = CaptchaVerdict{ErrorCodes: []string{"missing-input-response"}}
+	}
+	if verdict.Status != 0 && verdict.Status != http.StatusOK {
+		http.Error(w, http.StatusText(verdict.Status), verdict.Status)
+		return
+	}
+
+	response := siteverifyResponse{Success: verdict.Success, ErrorCodes: verdict.ErrorCodes}
+	if verdict.Success {
+		response.Score = verdict.Score
+		response.Action = verdict.Action
+		response.Hostname = verdict.Hostname
+		if response.Hostname == "" {
+			response.Hostname = hostname
+		}
+		response.ChallengeTS = time.Now().Add(-verdict.Age).UTC().Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) verifyKey(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	valid := len(s.keys) == 0 || s.keys[r.PostForm.Get("key")]
+	s.mu.Unlock()
+
+	if !valid || r.PostForm.Get("blog") == "" {
+		w.Header().Set("X-akismet-debug-help", "We were unable to verify your API key.")
+		w.Write([]byte("invalid"))
+		return
+	}
+	w.Write([]byte("valid"))
+}
+
+func (s *Server) commentCheck(w http.ResponseWriter, r *http.Request) {
+	author := r.PostForm.Get("comment_author")
+	content := r.PostForm.Get("comment_content")
+
+	verdict := AkismetVerdict{Spam: author == SpamAuthor}
+	s.mu.Lock()
+	for _, rule := range s.akismet {
+		if strings.Contains(author, rule.phrase) || strings.Contains(content, rule.phrase) {
+			verdict = rule.verdict
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if verdict.Status != 0 && verdict.Status != http.StatusOK {
+		http.Error(w, http.StatusText(verdict.Status), verdict.Status)
+		return
+	}
+	if verdict.DebugHelp != "" {
+		w.Header().Set("X-akismet-debug-help", verdict.DebugHelp)
+	}
+	if verdict.Spam {
+		w.Write([]byte("true"))
+	} else {
+		w.Write([]byte("false"))
+	}
+}
@@ -0,0 +1,241 @@
+package guestbook_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileStore writes guestbook entries as YAML files into a local checkout of the
+// Hugo site. Approved entries live in data/guestbook so Hugo renders them,
+// everything else is kept under .guestbook/<status> where Hugo never looks.
+type FileStore struct {
+	root string
+	mu   sync.Mutex
+}
+
+func NewFileStore(root string) *FileStore {
+	if root == "" {
+		return nil
+	}
+	return &FileStore{root: root}
+}
+
+func (f *FileStore) statusDir(status EntryStatus) string {
+	if status == StatusApproved {
+		return filepath.Join(f.root, "data", "guestbook")
+	}
+	return filepath.Join(f.root, ".guestbook", string(status))
+}
+
+func (f *FileStore) CreateEntry(ctx context.Context, entry *GuestbookEntry) error {
+	if f == nil {
+		return fmt.Errorf("file store not configured")
+	}
+	if entry.Status == "" {
+		entry.Status = StatusPending
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writeEntry(entry, entryFileName(entry))
+}
+
+func (f *FileStore) writeEntry(entry *GuestbookEntry, name string) error {
+	yamlData, err := yaml.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+
+	dir := f.statusDir(entry.Status)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create entry file: %w", err)
+	}
+	if _, err := file.Write(yamlData); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write entry file: %w", err)
+	}
+	return file.Close()
+}
+
+func (f *FileStore) GetEntry(ctx context.Context, id string) (*GuestbookEntry, error) {
+	if f == nil {
+		return nil, fmt.Errorf("file store not configured")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, _, err := f.findEntry(id)
+	return entry, err
+}
+
+func (f *FileStore) ListEntries(ctx context.Context, status EntryStatus) ([]*GuestbookEntry, error) {
+	if f == nil {
+		return nil, fmt.Errorf("file store not configured")
+	}
+
+	statuses := entryStatuses
+	if status != "" {
+		statuses = []EntryStatus{status}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var entries []*GuestbookEntry
+	for _, st := range statuses {
+		found, err := f.readDir(st)
+		if err != nil {
+			return nil, err
+		}
+		for _, fe := range found {
+			entries = append(entries, fe.entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Date > entries[j].Date })
+	return entries, nil
+}
+
+func (f *FileStore) UpdateEntryStatus(ctx context.Context, id string, status EntryStatus) error {
+	if f == nil {
+		return fmt.Errorf("file store not configured")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, path, err := f.findEntry(id)
+	if err != nil {
+		return err
+	}
+	if entry.Status == status {
+		return nil
+	}
+
+	entry.Status = status
+	if err := f.writeEntry(entry, filepath.Base(path)); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// UpdateEntry rewrites the file of an existing entry with its new content
+func (f *FileStore) UpdateEntry(ctx context.Context, entry *GuestbookEntry) error {
+	if f == nil {
+		return fmt.Errorf("file store not configured")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, path, err := f.findEntry(entry.ID)
+	if err != nil {
+		return err
+	}
+
+	existing.Name = entry.Name
+	existing.Message = entry.Message
+	yamlData, err := yaml.Marshal(existing)
+	if err != nil {
+		return fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+
+	// Write a temporary file first so a failed write never loses the entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(yamlData); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write entry file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write entry file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write entry file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileStore) DeleteEntry(ctx context.Context, id string) error {
+	if f == nil {
+		return fmt.Errorf("file store not configured")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, path, err := f.findEntry(id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+type fileEntry struct {
+	entry *GuestbookEntry
+	path  string
+}
+
+// readDir loads every entry file stored under the directory for status
+func (f *FileStore) readDir(status EntryStatus) ([]fileEntry, error) {
+	dir := f.statusDir(status)
+	dirEntries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	var entries []fileEntry
+	for _, de := range dirEntries {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), ".yml") {
+			continue
+		}
+		path := filepath.Join(dir, de.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var entry GuestbookEntry
+		if err := yaml.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		entry.Status = status
+		entries = append(entries, fileEntry{entry: &entry, path: path})
+	}
+	return entries, nil
+}
+
+func (f *FileStore) findEntry(id string) (*GuestbookEntry, string, error) {
+	for _, status := range entryStatuses {
+		entries, err := f.readDir(status)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, fe := range entries {
+			if fe.entry.ID == id {
+				return fe.entry, fe.path, nil
+			}
+		}
+	}
+	return nil, "", ErrEntryNotFound
+}
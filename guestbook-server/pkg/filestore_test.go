@@ -0,0 +1,83 @@
+package guestbook_server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNewFileStore(t *testing.T) {
+	assert.NotNil(t, NewFileStore(t.TempDir()))
+	assert.Nil(t, NewFileStore(""))
+}
+
+func TestFileStore_Lifecycle(t *testing.T) {
+	root := t.TempDir()
+	store := NewFileStore(root)
+	ctx := context.Background()
+
+	entry := &GuestbookEntry{ID: "20240101120000", Name: "John Doe", Message: "Hello!", Date: 1704110400}
+	require.NoError(t, store.CreateEntry(ctx, entry))
+	assert.Equal(t, StatusPending, entry.Status)
+	assert.FileExists(t, filepath.Join(root, ".guestbook", "pending", "entry20240101120000.yml"))
+
+	// Creating the same entry twice must not overwrite it
+	assert.Error(t, store.CreateEntry(ctx, &GuestbookEntry{ID: "20240101120000", Date: 1704110400}))
+
+	// Entries from the same second are kept apart
+	sameSecond := &GuestbookEntry{ID: "20240101120000-1a2b3c4d", Name: "Jane", Date: 1704110400}
+	require.NoError(t, store.CreateEntry(ctx, sameSecond))
+	require.NoError(t, store.DeleteEntry(ctx, sameSecond.ID))
+
+	got, err := store.GetEntry(ctx, entry.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "John Doe", got.Name)
+	assert.Equal(t, StatusPending, got.Status)
+
+	// Approving moves the file into the Hugo data directory
+	require.NoError(t, store.UpdateEntryStatus(ctx, entry.ID, StatusApproved))
+	published := filepath.Join(root, "data", "guestbook", "entry20240101120000.yml")
+	assert.FileExists(t, published)
+	assert.NoFileExists(t, filepath.Join(root, ".guestbook", "pending", "entry20240101120000.yml"))
+
+	data, err := os.ReadFile(published)
+	require.NoError(t, err)
+	var onDisk map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(data, &onDisk))
+	assert.Equal(t, "20240101120000", onDisk["_id"])
+	assert.NotContains(t, onDisk, "status")
+
+	approved, err := store.ListEntries(ctx, StatusApproved)
+	require.NoError(t, err)
+	require.Len(t, approved, 1)
+	assert.Equal(t, entry.ID, approved[0].ID)
+
+	pending, err := store.ListEntries(ctx, StatusPending)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	require.NoError(t, store.DeleteEntry(ctx, entry.ID))
+	assert.NoFileExists(t, published)
+
+	_, err = store.GetEntry(ctx, entry.ID)
+	assert.ErrorIs(t, err, ErrEntryNotFound)
+}
+
+func TestFileStore_ReadsExistingHugoData(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "data", "guestbook")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "entry1701931283189.yml"),
+		[]byte("_id: a303c7f0-94cb-11ee-88e9-4bb7cf239d09\nname: b10a\nmessage: Hello World!\ndate: 1701931283\n"), 0o644))
+
+	entries, err := NewFileStore(root).ListEntries(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "a303c7f0-94cb-11ee-88e9-4bb7cf239d09", entries[0].ID)
+	assert.Equal(t, StatusApproved, entries[0].Status)
+}
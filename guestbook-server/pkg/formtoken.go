@@ -0,0 +1,225 @@
+package guestbook_server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultFormTokenMinAge is how long a visitor takes at least to fill in
+	// the form; bots post as soon as the page loads
+	defaultFormTokenMinAge = 3 * time.Second
+	// defaultFormTokenMaxAge is how long a page can stay open before the form
+	// has to be reloaded
+	defaultFormTokenMaxAge = 2 * time.Hour
+)
+
+// Reasons a form token is not accepted
+var (
+	errFormTokenMissing = errors.New("no form token")
+	errFormTokenInvalid = errors.New("forged or malformed form token")
+	errFormTokenPage    = errors.New("form token issued for another page")
+	errFormTokenExpired = errors.New("form token expired")
+	errFormTokenTooFast = errors.New("form submitted too soon after loading")
+)
+
+// FormTokens issues and checks signed, timestamped form tokens. The form
+// fetches one from GET /form-token when the page loads and submits it as
+// "form-token", along with its page's path as "form-page". Bots that post without running the page's JavaScript have
+// no token, bots that fill in the form instantly submit it too soon, and
+// captured posts replayed later carry an expired one, all without a
+// third-party call.
+type FormTokens struct {
+	secret  []byte
+	minAge  time.Duration
+	maxAge  time.Duration
+	nowFunc func() time.Time
+}
+
+// signingKey returns secret as an HMAC key, or a random key when it is
+// empty. Random keys invalidate outstanding tokens on restart, so servers
+// behind a load balancer must share a secret.
+func signingKey(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate signing key: %v", err))
+	}
+	return key
+}
+
+// NewFormTokens creates form tokens signed with secret. Zero ages use the
+// defaults.
+func NewFormTokens(secret string, minAge, maxAge time.Duration) *FormTokens {
+	if minAge <= 0 {
+		minAge = defaultFormTokenMinAge
+	}
+	if maxAge <= 0 {
+		maxAge = defaultFormTokenMaxAge
+	}
+	return &FormTokens{
+		secret:  signingKey(secret),
+		minAge:  minAge,
+		maxAge:  maxAge,
+		nowFunc: time.Now,
+	}
+}
+
+// FormToken is the response of GET /form-token
+type FormToken struct {
+	Token     string `json:"token"`
+	Page      string `json:"page"`
+	MinAge    int    `json:"min_age"`    // Seconds before the form can be submitted
+	ExpiresAt int64  `json:"expires_at"` // Unix seconds
+}
+
+// formPage reduces a page URL or path to the path tokens are bound to
+func formPage(page string) string {
+	if u, err := url.Parse(page); err == nil {
+		page = u.Path
+	}
+	page = strings.TrimSuffix(page, "/")
+	if page == "" {
+		return "/"
+	}
+	return page
+}
+
+// sign returns the signature of the token payload
+func (f *FormTokens) sign(payload string) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Issue creates a token for the form on page, of the form
+// "<issued>.<base64 page>.<signature>"
+func (f *FormTokens) Issue(page string) FormToken {
+	page = formPage(page)
+	issued := f.nowFunc()
+	payload := fmt.Sprintf("%d.%s", issued.Unix(), base64.RawURLEncoding.EncodeToString([]byte(page)))
+	return FormToken{
+		Token:     payload + "." + f.sign(payload),
+		Page:      page,
+		MinAge:    int(f.minAge / time.Second),
+		ExpiresAt: issued.Add(f.maxAge).Unix(),
+	}
+}
+
+// Verify checks a token submitted from page, which is not compared when
+// empty, and returns how long ago it was issued
+func (f *FormTokens) Verify(token, page string) (time.Duration, error) {
+	if token == "" {
+		return 0, errFormTokenMissing
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, errFormTokenInvalid
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(f.sign(payload))) {
+		return 0, errFormTokenInvalid
+	}
+	issuedUnix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, errFormTokenInvalid
+	}
+	issuedPage, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, errFormTokenInvalid
+	}
+	if page != "" && formPage(page) != string(issuedPage) {
+		return 0, errFormTokenPage
+	}
+
+	age := f.nowFunc().Sub(time.Unix(issuedUnix, 0))
+	if age > f.maxAge {
+		return age, errFormTokenExpired
+	}
+	if age < f.minAge {
+		return age, errFormTokenTooFast
+	}
+	return age, nil
+}
+
+// submittedPage returns the page a submission was sent from: its form-page
+// field, or else its Referer. The form posts cross-origin, so browsers
+// usually send only the site's origin as Referer, which does not say which
+// page the form was on and is not compared.
+func submittedPage(sub *Submission) string {
+	if sub.Request.FormPage != "" {
+		return sub.Request.FormPage
+	}
+	if formPage(sub.Entry.Referrer) == "/" {
+		return ""
+	}
+	return sub.Entry.Referrer
+}
+
+// handleFormToken issues a form token for the page in the "page" query
+// parameter
+func (s *Server) handleFormToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, s.formTokens.Issue(c.Query("page")))
+}
+
+// formTokenChecker rejects submissions without a valid form token, or sent
+// too soon after the form was loaded
+type formTokenChecker struct {
+	tokens *FormTokens
+	weight float64
+}
+
+func (formTokenChecker) Name() string { return "formtoken" }
+
+func (f formTokenChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	if f.tokens == nil {
+		return CheckResult{Verdict: VerdictSkipped, Details: "form tokens not enabled"}
+	}
+
+	// Without a page to compare, any page's token is accepted
+	age, err := f.tokens.Verify(sub.Request.FormToken, submittedPage(sub))
+	switch {
+	case err == nil:
+		return CheckResult{Verdict: VerdictPass, Details: fmt.Sprintf("submitted %s after loading", age.Round(time.Second))}
+	case errors.Is(err, errFormTokenExpired):
+		// Most likely a visitor who left the page open, so they are told to
+		// reload rather than being treated as a bot
+		serverDebugLog("Expired form token from IP: %s", sub.Entry.UserIP)
+		return CheckResult{
+			Verdict:  VerdictSpam,
+			Details:  err.Error(),
+			Decisive: true,
+			Rejection: &Rejection{
+				Status: StatusRejected,
+				Reason: err.Error(),
+				Code:   http.StatusBadRequest,
+				Error:  "The form has expired, please reload the page and try again",
+			},
+		}
+	case errors.Is(err, errFormTokenTooFast):
+		err = fmt.Errorf("%w (%s)", err, age.Round(time.Millisecond))
+	}
+	serverDebugLog("Form token check failed for IP %s: %v", sub.Entry.UserIP, err)
+	return CheckResult{
+		Verdict:   VerdictSpam,
+		Details:   err.Error(),
+		SpamScore: f.weight,
+		Rejection: silentSpam(err.Error()),
+	}
+}
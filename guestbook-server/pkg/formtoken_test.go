@@ -0,0 +1,159 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormTokens_Verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tokens := NewFormTokens("secret", 0, 0)
+	tokens.nowFunc = func() time.Time { return now }
+
+	issued := tokens.Issue("https://b10a.co/guestbook/")
+	assert.Equal(t, "/guestbook", issued.Page)
+	assert.Equal(t, 3, issued.MinAge)
+	assert.Equal(t, now.Add(defaultFormTokenMaxAge).Unix(), issued.ExpiresAt)
+
+	_, err := tokens.Verify(issued.Token, "https://b10a.co/guestbook/")
+	assert.ErrorIs(t, err, errFormTokenTooFast)
+
+	now = now.Add(10 * time.Second)
+	age, err := tokens.Verify(issued.Token, "https://b10a.co/guestbook/")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, age)
+	_, err = tokens.Verify(issued.Token, "")
+	assert.NoError(t, err, "the page is not compared without a Referer")
+
+	_, err = tokens.Verify(issued.Token, "https://b10a.co/contact/")
+	assert.ErrorIs(t, err, errFormTokenPage)
+
+	_, err = tokens.Verify("", "")
+	assert.ErrorIs(t, err, errFormTokenMissing)
+	for name, token := range map[string]string{
+		"malformed":       "abc",
+		"tampered time":   strings.Replace(issued.Token, "1700000000", "1699990000", 1),
+		"other secret":    NewFormTokens("other", 0, 0).Issue("/guestbook").Token,
+		"bad page base64": "1700000000.!!!." + tokens.sign("1700000000.!!!"),
+	} {
+		_, err := tokens.Verify(token, "")
+		assert.ErrorIs(t, err, errFormTokenInvalid, name)
+	}
+
+	now = now.Add(defaultFormTokenMaxAge)
+	_, err = tokens.Verify(issued.Token, "")
+	assert.ErrorIs(t, err, errFormTokenExpired)
+}
+
+func TestFormTokenChecker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tokens := NewFormTokens("secret", 0, time.Hour)
+	tokens.nowFunc = func() time.Time { return now }
+	checker := formTokenChecker{tokens: tokens, weight: 1}
+
+	sub := newTestSubmission()
+	assert.Equal(t, VerdictSkipped, formTokenChecker{}.Check(context.Background(), sub).Verdict)
+
+	result := checker.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSpam, result.Verdict)
+	assert.Equal(t, 1.0, result.SpamScore)
+	require.NotNil(t, result.Rejection)
+	assert.Equal(t, http.StatusOK, result.Rejection.Code, "bots are not told they were caught")
+
+	sub.Request.FormToken = tokens.Issue("/guestbook").Token
+	result = checker.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSpam, result.Verdict)
+	assert.Contains(t, result.Details, "too soon")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, VerdictPass, checker.Check(context.Background(), sub).Verdict)
+
+	// The page is taken from the form, as cross-origin posts only carry the
+	// site's origin in their Referer
+	sub.Entry.Referrer = "https://b10a.co/"
+	assert.Equal(t, VerdictPass, checker.Check(context.Background(), sub).Verdict)
+	sub.Request.FormPage = "/guestbook/"
+	assert.Equal(t, VerdictPass, checker.Check(context.Background(), sub).Verdict)
+	sub.Request.FormPage = "/contact/"
+	result = checker.Check(context.Background(), sub)
+	assert.Equal(t, VerdictSpam, result.Verdict)
+	assert.Contains(t, result.Details, "another page")
+	sub.Request.FormPage = ""
+	sub.Entry.Referrer = "https://b10a.co/contact/"
+	assert.Equal(t, VerdictSpam, checker.Check(context.Background(), sub).Verdict, "a full Referer is still compared")
+	sub.Entry.Referrer = ""
+
+	now = now.Add(2 * time.Hour)
+	result = checker.Check(context.Background(), sub)
+	assert.True(t, result.Decisive)
+	assert.Equal(t, StatusRejected, result.Rejection.Status)
+	assert.Equal(t, http.StatusBadRequest, result.Rejection.Code, "visitors are told to reload")
+	assert.Contains(t, result.Rejection.Error, "reload")
+}
+
+func TestGuestbookSubmission_FormToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{
+		AllowedOrigins:    []string{"*"},
+		RateLimitRequests: 100,
+		RateLimitWindow:   60,
+		FormTokens:        true,
+	})
+	now := time.Unix(1700000000, 0)
+	server.formTokens.nowFunc = func() time.Time { return now }
+	store := &MockEntryStore{}
+	server.store = store
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/form-token?page=/guestbook/", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var token FormToken
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &token))
+	assert.Equal(t, "/guestbook", token.Page)
+
+	submit := func() *httptest.ResponseRecorder {
+		jsonData, _ := json.Marshal(map[string]string{
+			"name":                 "Test User",
+			"message":              "Hello there",
+			"g-recaptcha-response": "test-token",
+			"form-token":           token.Token,
+			"form-page":            "/guestbook/",
+		})
+		req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		// The form posts cross-origin, so browsers only send the origin
+		req.Header.Set("Origin", "https://b10a.co")
+		req.Header.Set("Referer", "https://b10a.co/")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = submit()
+	assert.Equal(t, http.StatusOK, rr.Code, "instant submissions look accepted")
+	assert.Empty(t, store.entries)
+
+	now = now.Add(30 * time.Second)
+	rr = submit()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, store.entries, 1)
+}
+
+func TestFormTokenRoute_OnlyWhenEnabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{RateLimitRequests: 100, RateLimitWindow: 60})
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/form-token", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -2,7 +2,13 @@ package guestbook_server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v66/github"
@@ -10,14 +16,68 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	guestbookDataDir      = "data/guestbook"
+	guestbookBranchPrefix = "guestbook-entry-"
+	spamLabel             = "spam"
+	// defaultPendingBranch collects approved entries in pending-branch mode
+	defaultPendingBranch = "guestbook-pending"
+)
+
+// GitHubPublishMode is how GitHubClient publishes approved entries. Entries
+// still pending review always get a pull request of their own.
+type GitHubPublishMode string
+
+const (
+	// PublishPullRequest opens a pull request per entry (default)
+	PublishPullRequest GitHubPublishMode = "pull-request"
+	// PublishDirect commits approved entries straight to the base branch
+	PublishDirect GitHubPublishMode = "direct"
+	// PublishPendingBranch commits approved entries to one long-lived branch,
+	// with a single pull request to merge them all into the base branch
+	PublishPendingBranch GitHubPublishMode = "pending-branch"
+)
+
+// ParseGitHubPublishMode converts a string into a GitHubPublishMode, with
+// an empty string meaning PublishPullRequest
+func ParseGitHubPublishMode(s string) (GitHubPublishMode, error) {
+	switch mode := GitHubPublishMode(s); mode {
+	case "":
+		return PublishPullRequest, nil
+	case PublishPullRequest, PublishDirect, PublishPendingBranch:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown GitHub publish mode %q", s)
+	}
+}
+
 type GitHubClient struct {
 	client *github.Client
 	owner  string
 	repo   string
 	branch string
+	// mode and pendingBranch control how approved entries are published
+	mode          GitHubPublishMode
+	pendingBranch string
+}
+
+// GitHubOption configures a GitHubClient
+type GitHubOption func(*GitHubClient)
+
+// WithPublishMode sets how approved entries are published. pendingBranch
+// is the branch used by PublishPendingBranch, guestbook-pending when empty.
+func WithPublishMode(mode GitHubPublishMode, pendingBranch string) GitHubOption {
+	return func(g *GitHubClient) {
+		if mode != "" {
+			g.mode = mode
+		}
+		if pendingBranch != "" {
+			g.pendingBranch = pendingBranch
+		}
+	}
 }
 
-func NewGitHubClient(token, owner, repo, branch string) *GitHubClient {
+func NewGitHubClient(token, owner, repo, branch string, opts ...GitHubOption) *GitHubClient {
 	if token == "" {
 		return nil
 	}
@@ -29,23 +89,39 @@ func NewGitHubClient(token, owner, repo, branch string) *GitHubClient {
 	tc := oauth2.NewClient(ctx, ts)
 	client := github.NewClient(tc)
 
-	return &GitHubClient{
-		client: client,
-		owner:  owner,
-		repo:   repo,
-		branch: branch,
+	return newGitHubClient(client, owner, repo, branch, opts...)
+}
+
+func newGitHubClient(client *github.Client, owner, repo, branch string, opts ...GitHubOption) *GitHubClient {
+	g := &GitHubClient{
+		client:        client,
+		owner:         owner,
+		repo:          repo,
+		branch:        branch,
+		mode:          PublishPullRequest,
+		pendingBranch: defaultPendingBranch,
+	}
+	for _, opt := range opts {
+		opt(g)
 	}
+	return g
 }
 
+// CreateGuestbookEntry opens a pull request for the entry built from req
 func (g *GitHubClient) CreateGuestbookEntry(ctx context.Context, req GuestbookRequest) error {
+	return g.CreateEntry(ctx, req.ToEntry())
+}
+
+// CreateEntry opens a pull request adding the entry to data/guestbook. The entry
+// stays pending until the pull request is merged. Approved entries are
+// committed directly instead when the publish mode is not PublishPullRequest.
+func (g *GitHubClient) CreateEntry(ctx context.Context, entry *GuestbookEntry) error {
 	if g == nil {
 		return fmt.Errorf("GitHub client not configured")
 	}
 
-	entry := req.ToEntry()
-
 	// Generate filename
-	filename := fmt.Sprintf("data/guestbook/entry%d.yml", time.Now().Unix())
+	filename := entryPath(entry)
 
 	// Convert entry to YAML
 	yamlData, err := yaml.Marshal(entry)
@@ -53,6 +129,10 @@ func (g *GitHubClient) CreateGuestbookEntry(ctx context.Context, req GuestbookRe
 		return fmt.Errorf("failed to marshal YAML: %w", err)
 	}
 
+	if entry.Status == StatusApproved && g.mode != PublishPullRequest {
+		return g.commitEntry(ctx, entry, filename, yamlData)
+	}
+
 	// Get current main branch
 	ref, _, err := g.client.Git.GetRef(ctx, g.owner, g.repo, "refs/heads/"+g.branch)
 	if err != nil {
@@ -60,7 +140,7 @@ func (g *GitHubClient) CreateGuestbookEntry(ctx context.Context, req GuestbookRe
 	}
 
 	// Create new branch
-	branchName := fmt.Sprintf("guestbook-entry-%d", time.Now().Unix())
+	branchName := entryBranch(entry)
 	newRef := &github.Reference{
 		Ref: github.String("refs/heads/" + branchName),
 		Object: &github.GitObject{
@@ -103,5 +183,479 @@ func (g *GitHubClient) CreateGuestbookEntry(ctx context.Context, req GuestbookRe
 		return fmt.Errorf("failed to create pull request: %w", err)
 	}
 
+	entry.Status = StatusPending
+	return nil
+}
+
+// commitEntry commits an approved entry to the base branch, or to the
+// pending branch in PublishPendingBranch mode
+func (g *GitHubClient) commitEntry(ctx context.Context, entry *GuestbookEntry, filename string, yamlData []byte) error {
+	branch := g.branch
+	if g.mode == PublishPendingBranch {
+		if err := g.ensurePendingBranch(ctx); err != nil {
+			return err
+		}
+		branch = g.pendingBranch
+	}
+
+	_, _, err := g.client.Repositories.CreateFile(ctx, g.owner, g.repo, filename, &github.RepositoryContentFileOptions{
+		Message: github.String(fmt.Sprintf("Add guestbook entry from %s", entry.Name)),
+		Content: yamlData,
+		Branch:  github.String(branch),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit entry to %s: %w", branch, err)
+	}
+
+	if g.mode == PublishPendingBranch {
+		if err := g.ensurePendingPullRequest(ctx); err != nil {
+			return err
+		}
+	}
+	entry.Status = StatusApproved
+	return nil
+}
+
+// ensurePendingBranch creates the pending branch from the base branch unless
+// it exists
+func (g *GitHubClient) ensurePendingBranch(ctx context.Context) error {
+	_, resp, err := g.client.Git.GetRef(ctx, g.owner, g.repo, "refs/heads/"+g.pendingBranch)
+	if err == nil {
+		return nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to get %s branch ref: %w", g.pendingBranch, err)
+	}
+
+	base, _, err := g.client.Git.GetRef(ctx, g.owner, g.repo, "refs/heads/"+g.branch)
+	if err != nil {
+		return fmt.Errorf("failed to get %s branch ref: %w", g.branch, err)
+	}
+	_, _, err = g.client.Git.CreateRef(ctx, g.owner, g.repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + g.pendingBranch),
+		Object: &github.GitObject{SHA: base.Object.SHA},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s branch: %w", g.pendingBranch, err)
+	}
+	return nil
+}
+
+// ensurePendingPullRequest opens the pull request merging the pending branch
+// into the base branch unless one is already open
+func (g *GitHubClient) ensurePendingPullRequest(ctx context.Context) error {
+	prs, _, err := g.client.PullRequests.List(ctx, g.owner, g.repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  g.owner + ":" + g.pendingBranch,
+		Base:  g.branch,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pull requests: %w", err)
+	}
+	if len(prs) > 0 {
+		return nil
+	}
+
+	_, _, err = g.client.PullRequests.Create(ctx, g.owner, g.repo, &github.NewPullRequest{
+		Title: github.String("Approved guestbook entries"),
+		Head:  github.String(g.pendingBranch),
+		Base:  github.String(g.branch),
+		Body:  github.String("Guestbook entries approved since the last merge. Merge to publish them."),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create pull request: %w", err)
+	}
+	return nil
+}
+
+func (g *GitHubClient) GetEntry(ctx context.Context, id string) (*GuestbookEntry, error) {
+	if g == nil {
+		return nil, fmt.Errorf("GitHub client not configured")
+	}
+
+	found, err := g.findEntry(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return found.entry, nil
+}
+
+// ListEntries reads published entries from data/guestbook on the base branch
+// and unpublished ones from the pull requests opened by CreateEntry. Closed
+// pull requests labelled "spam" are reported as spam, other closed ones as
+// rejected.
+func (g *GitHubClient) ListEntries(ctx context.Context, status EntryStatus) ([]*GuestbookEntry, error) {
+	if g == nil {
+		return nil, fmt.Errorf("GitHub client not configured")
+	}
+
+	found, err := g.listEntries(ctx, status)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*GuestbookEntry, 0, len(found))
+	for _, ge := range found {
+		entries = append(entries, ge.entry)
+	}
+	return entries, nil
+}
+
+// UpdateEntryStatus merges the entry's pull request to approve it, or closes it
+// to reject it. Rejecting an already published entry removes its file.
+func (g *GitHubClient) UpdateEntryStatus(ctx context.Context, id string, status EntryStatus) error {
+	if g == nil {
+		return fmt.Errorf("GitHub client not configured")
+	}
+
+	found, err := g.findEntry(ctx, id)
+	if err != nil {
+		return err
+	}
+	if found.entry.Status == status {
+		return nil
+	}
+
+	switch {
+	case found.pr != nil && found.entry.Status == StatusPending && status == StatusApproved:
+		_, _, err := g.client.PullRequests.Merge(ctx, g.owner, g.repo, found.pr.GetNumber(),
+			fmt.Sprintf("Approve guestbook entry from %s", found.entry.Name), nil)
+		if err != nil {
+			return fmt.Errorf("failed to merge pull request: %w", err)
+		}
+		return g.deleteBranch(ctx, found.pr)
+	case found.pr != nil && found.entry.Status == StatusPending:
+		if status == StatusSpam {
+			if _, _, err := g.client.Issues.AddLabelsToIssue(ctx, g.owner, g.repo, found.pr.GetNumber(), []string{spamLabel}); err != nil {
+				return fmt.Errorf("failed to label pull request: %w", err)
+			}
+		}
+		return g.closePullRequest(ctx, found.pr)
+	case found.pr == nil && found.entry.Status == StatusApproved && (status == StatusRejected || status == StatusSpam):
+		return g.deleteFile(ctx, found)
+	default:
+		return fmt.Errorf("cannot change %s entry %s to %s on GitHub", found.entry.Status, id, status)
+	}
+}
+
+// DeleteEntry closes the entry's pull request, or removes the published file
+func (g *GitHubClient) DeleteEntry(ctx context.Context, id string) error {
+	if g == nil {
+		return fmt.Errorf("GitHub client not configured")
+	}
+
+	found, err := g.findEntry(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if found.pr != nil {
+		if found.pr.GetState() == "open" {
+			return g.closePullRequest(ctx, found.pr)
+		}
+		return nil
+	}
+	return g.deleteFile(ctx, found)
+}
+
+// githubEntry locates an entry either on a branch or in a pull request
+type githubEntry struct {
+	entry  *GuestbookEntry
+	path   string
+	sha    string
+	branch string
+	pr     *github.PullRequest
+}
+
+func entryPath(entry *GuestbookEntry) string {
+	return guestbookDataDir + "/" + entryFileName(entry)
+}
+
+// entryBranch names the pull request branch of an entry
+func entryBranch(entry *GuestbookEntry) string {
+	if entry.ID == "" {
+		return fmt.Sprintf("%s%d", guestbookBranchPrefix, entry.Date)
+	}
+	return guestbookBranchPrefix + entry.ID
+}
+
+// branchEntry identifies the entry of a pull request branch. Branches
+// opened before entry IDs were unique are named after the entry's date.
+func branchEntry(branch string) (*GuestbookEntry, bool) {
+	suffix, ok := strings.CutPrefix(branch, guestbookBranchPrefix)
+	if !ok || suffix == "" {
+		return nil, false
+	}
+	if strings.Contains(suffix, "-") {
+		return &GuestbookEntry{ID: suffix}, true
+	}
+	date, err := strconv.ParseInt(suffix, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &GuestbookEntry{Date: date}, true
+}
+
+// findEntry looks an entry up by the file and branch names derived from its
+// ID: the published file, then the file on the pending branch, then its pull
+// request. Only entries whose files predate unique IDs need a full scan.
+func (g *GitHubClient) findEntry(ctx context.Context, id string) (*githubEntry, error) {
+	if !strings.Contains(id, "-") {
+		return g.scanEntries(ctx, id)
+	}
+	stub := &GuestbookEntry{ID: id}
+
+	branches := []string{g.branch}
+	if g.mode == PublishPendingBranch {
+		branches = append(branches, g.pendingBranch)
+	}
+	for _, branch := range branches {
+		ge, err := g.readEntry(ctx, entryPath(stub), branch)
+		if err == nil {
+			ge.entry.Status = StatusApproved
+			ge.branch = branch
+			return ge, nil
+		}
+		if !errors.Is(err, ErrEntryNotFound) {
+			return nil, err
+		}
+	}
+
+	prs, _, err := g.client.PullRequests.List(ctx, g.owner, g.repo, &github.PullRequestListOptions{
+		State: "all",
+		Head:  g.owner + ":" + entryBranch(stub),
+		Base:  g.branch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests: %w", err)
+	}
+	for _, pr := range prs {
+		status, ok := pullRequestStatus(pr)
+		if !ok {
+			continue
+		}
+		ge, err := g.readEntry(ctx, entryPath(stub), pr.GetHead().GetSHA())
+		if err != nil {
+			return nil, err
+		}
+		ge.entry.Status = status
+		ge.pr = pr
+		return ge, nil
+	}
+	return nil, ErrEntryNotFound
+}
+
+// scanEntries finds an entry by listing every entry
+func (g *GitHubClient) scanEntries(ctx context.Context, id string) (*githubEntry, error) {
+	found, err := g.listEntries(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, ge := range found {
+		if ge.entry.ID == id {
+			return ge, nil
+		}
+	}
+	return nil, ErrEntryNotFound
+}
+
+func (g *GitHubClient) listEntries(ctx context.Context, status EntryStatus) ([]*githubEntry, error) {
+	var found []*githubEntry
+
+	if status == "" || status == StatusApproved {
+		published, err := g.listPublished(ctx, g.branch)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, published...)
+
+		if g.mode == PublishPendingBranch {
+			waiting, err := g.listWaiting(ctx, published)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, waiting...)
+		}
+	}
+
+	if status != StatusApproved {
+		fromPRs, err := g.listPullRequestEntries(ctx, status)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, fromPRs...)
+	}
+
+	return found, nil
+}
+
+// listWaiting returns the approved entries on the pending branch that have
+// not been merged into the base branch yet
+func (g *GitHubClient) listWaiting(ctx context.Context, published []*githubEntry) ([]*githubEntry, error) {
+	onPending, err := g.listPublished(ctx, g.pendingBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]bool, len(published))
+	for _, ge := range published {
+		merged[ge.path] = true
+	}
+	var found []*githubEntry
+	for _, ge := range onPending {
+		if !merged[ge.path] {
+			found = append(found, ge)
+		}
+	}
+	return found, nil
+}
+
+// listPublished reads the entries in data/guestbook on branch
+func (g *GitHubClient) listPublished(ctx context.Context, branch string) ([]*githubEntry, error) {
+	_, dir, resp, err := g.client.Repositories.GetContents(ctx, g.owner, g.repo, guestbookDataDir,
+		&github.RepositoryContentGetOptions{Ref: branch})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", guestbookDataDir, err)
+	}
+
+	var found []*githubEntry
+	for _, item := range dir {
+		if item.GetType() != "file" || !strings.HasSuffix(item.GetName(), ".yml") {
+			continue
+		}
+		ge, err := g.readEntry(ctx, item.GetPath(), branch)
+		if err != nil {
+			log.Printf("Skipping %s on %s: %v", item.GetPath(), branch, err)
+			continue
+		}
+		ge.entry.Status = StatusApproved
+		ge.branch = branch
+		found = append(found, ge)
+	}
+	return found, nil
+}
+
+func (g *GitHubClient) listPullRequestEntries(ctx context.Context, status EntryStatus) ([]*githubEntry, error) {
+	state := "all"
+	if status == StatusPending {
+		state = "open"
+	} else if status == StatusRejected || status == StatusSpam {
+		state = "closed"
+	}
+
+	opts := &github.PullRequestListOptions{
+		State:       state,
+		Base:        g.branch,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	var found []*githubEntry
+	for {
+		prs, resp, err := g.client.PullRequests.List(ctx, g.owner, g.repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pull requests: %w", err)
+		}
+
+		for _, pr := range prs {
+			prStatus, ok := pullRequestStatus(pr)
+			if !ok || (status != "" && prStatus != status) {
+				continue
+			}
+			stub, ok := branchEntry(pr.GetHead().GetRef())
+			if !ok {
+				continue
+			}
+
+			ge, err := g.readEntry(ctx, entryPath(stub), pr.GetHead().GetSHA())
+			if err != nil {
+				// One broken pull request should not hide every other entry
+				log.Printf("Skipping pull request #%d: %v", pr.GetNumber(), err)
+				continue
+			}
+			ge.entry.Status = prStatus
+			ge.pr = pr
+			found = append(found, ge)
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return found, nil
+}
+
+// pullRequestStatus maps a guestbook pull request to the status of its entry.
+// Merged pull requests are skipped since their entry is already published.
+func pullRequestStatus(pr *github.PullRequest) (EntryStatus, bool) {
+	if !strings.HasPrefix(pr.GetHead().GetRef(), guestbookBranchPrefix) || pr.MergedAt != nil {
+		return "", false
+	}
+	if pr.GetState() == "open" {
+		return StatusPending, true
+	}
+	for _, label := range pr.Labels {
+		if label.GetName() == spamLabel {
+			return StatusSpam, true
+		}
+	}
+	return StatusRejected, true
+}
+
+func (g *GitHubClient) readEntry(ctx context.Context, filePath, ref string) (*githubEntry, error) {
+	file, _, resp, err := g.client.Repositories.GetContents(ctx, g.owner, g.repo, filePath,
+		&github.RepositoryContentGetOptions{Ref: ref})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s at %s: %w", filePath, ref, ErrEntryNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", filePath, err)
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%s is not a file", filePath)
+	}
+
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filePath, err)
+	}
+
+	var entry GuestbookEntry
+	if err := yaml.Unmarshal([]byte(content), &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path.Base(filePath), err)
+	}
+	return &githubEntry{entry: &entry, path: filePath, sha: file.GetSHA()}, nil
+}
+
+func (g *GitHubClient) closePullRequest(ctx context.Context, pr *github.PullRequest) error {
+	_, _, err := g.client.PullRequests.Edit(ctx, g.owner, g.repo, pr.GetNumber(),
+		&github.PullRequest{State: github.String("closed")})
+	if err != nil {
+		return fmt.Errorf("failed to close pull request: %w", err)
+	}
+	return g.deleteBranch(ctx, pr)
+}
+
+func (g *GitHubClient) deleteBranch(ctx context.Context, pr *github.PullRequest) error {
+	resp, err := g.client.Git.DeleteRef(ctx, g.owner, g.repo, "heads/"+pr.GetHead().GetRef())
+	// GitHub answers 422 when the branch was already deleted
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusUnprocessableEntity) {
+		return fmt.Errorf("failed to delete branch: %w", err)
+	}
+	return nil
+}
+
+func (g *GitHubClient) deleteFile(ctx context.Context, found *githubEntry) error {
+	_, _, err := g.client.Repositories.DeleteFile(ctx, g.owner, g.repo, found.path, &github.RepositoryContentFileOptions{
+		Message: github.String(fmt.Sprintf("Remove guestbook entry from %s", found.entry.Name)),
+		SHA:     github.String(found.sha),
+		Branch:  github.String(found.branch),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
 	return nil
 }
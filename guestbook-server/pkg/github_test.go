@@ -0,0 +1,277 @@
+package guestbook_server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitHub serves the parts of the GitHub REST API used by GitHubClient
+// for a repository with a "main" branch
+type fakeGitHub struct {
+	mu       sync.Mutex
+	calls    []string
+	branches map[string]map[string][]byte // branch -> path -> content
+	pulls    []map[string]any
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, *github.Client) {
+	t.Helper()
+	fake := &fakeGitHub{branches: map[string]map[string][]byte{"main": {}}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	return fake, client
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/")
+	f.calls = append(f.calls, r.Method+" "+p)
+
+	switch {
+	case r.Method == "GET" && strings.HasPrefix(p, "git/ref/heads/"):
+		branch := strings.TrimPrefix(p, "git/ref/heads/")
+		if _, ok := f.branches[branch]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ref": "refs/heads/" + branch, "object": map[string]string{"sha": "sha-" + branch}})
+	case r.Method == "POST" && p == "git/refs":
+		var ref github.Reference
+		json.NewDecoder(r.Body).Decode(&ref)
+		f.branches[strings.TrimPrefix(ref.GetRef(), "refs/heads/")] = map[string][]byte{}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ref)
+	case r.Method == "PUT" && strings.HasPrefix(p, "contents/"):
+		var opts struct {
+			Branch  string `json:"branch"`
+			Content []byte `json:"content"`
+		}
+		json.NewDecoder(r.Body).Decode(&opts)
+		f.branches[opts.Branch][strings.TrimPrefix(p, "contents/")] = opts.Content
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	case r.Method == "GET" && strings.HasPrefix(p, "contents/"):
+		f.serveContents(w, r, strings.TrimPrefix(p, "contents/"))
+	case r.Method == "GET" && p == "pulls":
+		pulls := []map[string]any{}
+		for _, pr := range f.pulls {
+			if head := r.URL.Query().Get("head"); head == "" || head == "owner:"+pr["head"].(map[string]any)["ref"].(string) {
+				pulls = append(pulls, pr)
+			}
+		}
+		json.NewEncoder(w).Encode(pulls)
+	case r.Method == "POST" && p == "pulls":
+		var pr github.NewPullRequest
+		json.NewDecoder(r.Body).Decode(&pr)
+		created := map[string]any{"number": len(f.pulls) + 1, "state": "open", "title": pr.GetTitle(),
+			"head": map[string]any{"ref": pr.GetHead(), "sha": pr.GetHead()}}
+		f.pulls = append(f.pulls, created)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeGitHub) serveContents(w http.ResponseWriter, r *http.Request, p string) {
+	files, ok := f.branches[r.URL.Query().Get("ref")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if content, ok := files[p]; ok {
+		json.NewEncoder(w).Encode(map[string]any{"type": "file", "path": p, "sha": "sha-" + p,
+			"encoding": "base64", "content": base64.StdEncoding.EncodeToString(content)})
+		return
+	}
+	var dir []map[string]string
+	for name := range files {
+		if path.Dir(name) == p {
+			dir = append(dir, map[string]string{"type": "file", "name": path.Base(name), "path": name})
+		}
+	}
+	if dir == nil {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(dir)
+}
+
+func (f *fakeGitHub) files(branch string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for name := range f.branches[branch] {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestParseGitHubPublishMode(t *testing.T) {
+	mode, err := ParseGitHubPublishMode("")
+	require.NoError(t, err)
+	assert.Equal(t, PublishPullRequest, mode)
+
+	mode, err = ParseGitHubPublishMode("pending-branch")
+	require.NoError(t, err)
+	assert.Equal(t, PublishPendingBranch, mode)
+
+	_, err = ParseGitHubPublishMode("push")
+	assert.Error(t, err)
+
+	_, err = newEntryStore(&Config{GitHubToken: "test-token", GitHubPublishMode: "push"})
+	assert.Error(t, err)
+}
+
+func TestGitHubClient_CreateEntry_PullRequest(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	g := newGitHubClient(client, "owner", "repo", "main")
+
+	entry := &GuestbookEntry{ID: "20231114221320-1a2b3c4d", Name: "Alice", Message: "Hi", Date: 1700000000, Status: StatusApproved}
+	require.NoError(t, g.CreateEntry(context.Background(), entry))
+	assert.Equal(t, StatusPending, entry.Status, "entries wait for their pull request to be merged")
+	assert.Equal(t, []string{"data/guestbook/entry20231114221320-1a2b3c4d.yml"}, fake.files("guestbook-entry-20231114221320-1a2b3c4d"))
+	assert.Empty(t, fake.files("main"))
+	assert.Len(t, fake.pulls, 1)
+}
+
+func TestGitHubClient_CreateEntry_Direct(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	g := newGitHubClient(client, "owner", "repo", "main", WithPublishMode(PublishDirect, ""))
+
+	entry := &GuestbookEntry{ID: "20231114221320-1a2b3c4d", Name: "Alice", Message: "Hi", Date: 1700000000, Status: StatusApproved}
+	require.NoError(t, g.CreateEntry(context.Background(), entry))
+	assert.Equal(t, StatusApproved, entry.Status)
+	assert.Equal(t, []string{"data/guestbook/entry20231114221320-1a2b3c4d.yml"}, fake.files("main"))
+	assert.Empty(t, fake.pulls)
+
+	// Entries held for review still get a pull request
+	pending := &GuestbookEntry{ID: "2", Name: "Bob", Message: "Hey", Date: 1700000100, Status: StatusPending}
+	require.NoError(t, g.CreateEntry(context.Background(), pending))
+	assert.Len(t, fake.pulls, 1)
+	assert.Len(t, fake.files("main"), 1)
+
+	entries, err := g.ListEntries(context.Background(), StatusApproved)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "Alice", entries[0].Name)
+}
+
+func TestGitHubClient_CreateEntry_PendingBranch(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	g := newGitHubClient(client, "owner", "repo", "main", WithPublishMode(PublishPendingBranch, ""))
+
+	for i, name := range []string{"Alice", "Bob"} {
+		entry := &GuestbookEntry{ID: name, Name: name, Message: "Hi", Date: int64(1700000000 + i), Status: StatusApproved}
+		require.NoError(t, g.CreateEntry(context.Background(), entry))
+		assert.Equal(t, StatusApproved, entry.Status)
+	}
+	assert.Len(t, fake.files("guestbook-pending"), 2)
+	assert.Empty(t, fake.files("main"))
+	require.Len(t, fake.pulls, 1, "one pull request collects every approved entry")
+	assert.Equal(t, "Approved guestbook entries", fake.pulls[0]["title"])
+
+	fake.mu.Lock()
+	created := 0
+	for _, call := range fake.calls {
+		if call == "POST git/refs" {
+			created++
+		}
+	}
+	fake.mu.Unlock()
+	assert.Equal(t, 1, created, "the pending branch is created once")
+
+	entries, err := g.ListEntries(context.Background(), StatusApproved)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "entries waiting on the pending branch are listed")
+}
+
+func TestBranchEntry(t *testing.T) {
+	entry := &GuestbookEntry{ID: "20231114221320-1a2b3c4d", Date: 1700000000}
+	found, ok := branchEntry(entryBranch(entry))
+	require.True(t, ok)
+	assert.Equal(t, entryPath(entry), entryPath(found))
+
+	// Branches opened before IDs were unique are named after the date
+	found, ok = branchEntry("guestbook-entry-1700000000")
+	require.True(t, ok)
+	assert.Equal(t, "data/guestbook/entry1700000000.yml", entryPath(found))
+
+	_, ok = branchEntry("feature-branch")
+	assert.False(t, ok)
+}
+
+// callCount returns how many requests the fake has served and forgets them
+func (f *fakeGitHub) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.calls)
+	f.calls = nil
+	return n
+}
+
+func TestGitHubClient_FindEntryByID(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	g := newGitHubClient(client, "owner", "repo", "main", WithPublishMode(PublishDirect, ""))
+	ctx := context.Background()
+
+	// Plenty of history, which lookups must not walk through
+	for i := 0; i < 20; i++ {
+		entry := &GuestbookEntry{ID: generateID(), Name: "Old", Date: int64(1600000000 + i), Status: StatusApproved}
+		require.NoError(t, g.CreateEntry(ctx, entry))
+	}
+	published := &GuestbookEntry{ID: "20231114221320-aaaaaaaa", Name: "Alice", Date: 1700000000, Status: StatusApproved}
+	require.NoError(t, g.CreateEntry(ctx, published))
+	pending := &GuestbookEntry{ID: "20231114221321-bbbbbbbb", Name: "Bob", Date: 1700000001, Status: StatusPending}
+	require.NoError(t, g.CreateEntry(ctx, pending))
+	fake.callCount()
+
+	got, err := g.GetEntry(ctx, published.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", got.Name)
+	assert.Equal(t, StatusApproved, got.Status)
+	assert.Equal(t, 1, fake.callCount(), "published entries are read from their path")
+
+	got, err = g.GetEntry(ctx, pending.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Bob", got.Name)
+	assert.Equal(t, StatusPending, got.Status)
+	assert.Equal(t, 3, fake.callCount(), "pending entries are found through their branch name")
+
+	_, err = g.GetEntry(ctx, "20231114221322-cccccccc")
+	assert.ErrorIs(t, err, ErrEntryNotFound)
+}
+
+func TestGitHubClient_ListSkipsBrokenPullRequests(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	g := newGitHubClient(client, "owner", "repo", "main")
+	ctx := context.Background()
+
+	require.NoError(t, g.CreateEntry(ctx, &GuestbookEntry{ID: "20231114221320-aaaaaaaa", Name: "Alice", Date: 1700000000}))
+	fake.mu.Lock()
+	// A pull request whose branch lost the entry file
+	fake.pulls = append(fake.pulls, map[string]any{"number": 99, "state": "open",
+		"head": map[string]any{"ref": "guestbook-entry-20231114221321-bbbbbbbb", "sha": "missing"}})
+	fake.mu.Unlock()
+
+	entries, err := g.ListEntries(ctx, StatusPending)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "Alice", entries[0].Name)
+}
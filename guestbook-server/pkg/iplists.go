@@ -0,0 +1,146 @@
+package guestbook_server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ipList is a list of IP ranges. Single addresses are stored as /32 or /128
+// prefixes.
+type ipList []netip.Prefix
+
+// parseIPRange parses an address such as "203.0.113.7" or a CIDR range such
+// as "203.0.113.0/24"
+func parseIPRange(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseIPList parses addresses and CIDR ranges
+func parseIPList(entries []string) (ipList, error) {
+	list := make(ipList, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := parseIPRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, prefix)
+	}
+	return list, nil
+}
+
+// loadIPList reads addresses and CIDR ranges, one per line, such as a
+// published list of hosting-provider ranges. Blank lines and "#" comments are
+// ignored.
+func loadIPList(path string) (ipList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IP list: %w", err)
+	}
+	defer file.Close()
+
+	var list ipList
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		prefix, err := parseIPRange(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		list = append(list, prefix)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read IP list %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// loadIPLists combines ranges from configuration with those in the file at
+// path, if any
+func loadIPLists(entries []string, path string) (ipList, error) {
+	list, err := parseIPList(entries)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		fromFile, err := loadIPList(path)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, fromFile...)
+	}
+	return list, nil
+}
+
+// Match returns the range containing ip
+func (l ipList) Match(ip netip.Addr) (netip.Prefix, bool) {
+	ip = ip.Unmap()
+	for _, prefix := range l {
+		if prefix.Contains(ip) {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// ipChecker looks the client IP up in the allow and block lists. It runs
+// before the checks that call out to reCAPTCHA and Akismet, so blocked IPs are
+// rejected without those calls. Allowlisted IPs skip the content checks
+// (akismet, heuristics and bayes, see contentChecks), but the honeypot, form
+// token and reCAPTCHA checks still run.
+type ipChecker struct {
+	allowed ipList
+	blocked ipList
+}
+
+func (ipChecker) Name() string { return "ip" }
+
+func (i ipChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	if len(i.allowed) == 0 && len(i.blocked) == 0 {
+		return CheckResult{Verdict: VerdictSkipped, Details: "no IP lists configured"}
+	}
+	ip, err := netip.ParseAddr(sub.Entry.UserIP)
+	if err != nil {
+		return CheckResult{Verdict: VerdictSkipped, Details: fmt.Sprintf("invalid IP %q", sub.Entry.UserIP)}
+	}
+
+	// Allowlisted addresses win, so a known good address inside a blocked
+	// datacenter range still gets through
+	if prefix, ok := i.allowed.Match(ip); ok {
+		serverDebugLog("IP %s is allowlisted by %s", ip, prefix)
+		return CheckResult{Verdict: VerdictPass, Details: fmt.Sprintf("allowlisted range %s", prefix), Trusted: true}
+	}
+	if prefix, ok := i.blocked.Match(ip); ok {
+		serverDebugLog("IP %s is blocked by %s", ip, prefix)
+		return CheckResult{
+			Verdict:   VerdictSpam,
+			Details:   fmt.Sprintf("blocked range %s", prefix),
+			Decisive:  true,
+			Rejection: silentSpam(fmt.Sprintf("IP %s is in blocked range %s", ip, prefix)),
+		}
+	}
+	return CheckResult{Verdict: VerdictPass}
+}
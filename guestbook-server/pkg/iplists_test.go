@@ -0,0 +1,177 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingVerifier records how often reCAPTCHA was called
+type countingVerifier struct {
+	calls int
+}
+
+func (c *countingVerifier) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	c.calls++
+	return true, nil
+}
+
+func TestParseIPList(t *testing.T) {
+	list, err := parseIPList([]string{"203.0.113.7", " 198.51.100.0/24 ", "", "2001:db8::/32", "10.1.2.3/8"})
+	require.NoError(t, err)
+	assert.Equal(t, ipList{
+		netip.MustParsePrefix("203.0.113.7/32"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+	}, list)
+
+	_, err = parseIPList([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
+
+func TestIPList_Match(t *testing.T) {
+	list, err := parseIPList([]string{"198.51.100.0/24", "2001:db8::/32"})
+	require.NoError(t, err)
+
+	prefix, ok := list.Match(netip.MustParseAddr("198.51.100.42"))
+	assert.True(t, ok)
+	assert.Equal(t, "198.51.100.0/24", prefix.String())
+	_, ok = list.Match(netip.MustParseAddr("::ffff:198.51.100.42"))
+	assert.True(t, ok, "IPv4-mapped addresses match IPv4 ranges")
+	_, ok = list.Match(netip.MustParseAddr("2001:db8:1::1"))
+	assert.True(t, ok)
+	_, ok = list.Match(netip.MustParseAddr("198.51.101.1"))
+	assert.False(t, ok)
+}
+
+func TestLoadIPList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosting.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# Hosting providers\n192.0.2.0/24  # example cloud\n\n2001:db8::/32\n"), 0644))
+	list, err := loadIPLists([]string{"203.0.113.7"}, path)
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+
+	require.NoError(t, os.WriteFile(path, []byte("192.0.2.0/24\nbogus\n"), 0644))
+	_, err = loadIPList(path)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestIPChecker(t *testing.T) {
+	allowed, _ := parseIPList([]string{"198.51.100.10"})
+	blocked, _ := parseIPList([]string{"198.51.100.0/24"})
+	checker := ipChecker{allowed: allowed, blocked: blocked}
+	check := func(ip string) CheckResult {
+		sub := newTestSubmission()
+		sub.Entry.UserIP = ip
+		return checker.Check(context.Background(), sub)
+	}
+
+	result := check("198.51.100.20")
+	assert.True(t, result.Decisive)
+	require.NotNil(t, result.Rejection)
+	assert.Equal(t, StatusSpam, result.Rejection.Status)
+	assert.Equal(t, "IP 198.51.100.20 is in blocked range 198.51.100.0/24", result.Rejection.Reason)
+
+	result = check("198.51.100.10")
+	assert.True(t, result.Trusted, "allowlisted addresses win over blocked ranges")
+	assert.Equal(t, VerdictPass, result.Verdict)
+
+	assert.Equal(t, CheckResult{Verdict: VerdictPass}, check("203.0.113.1"))
+	assert.Equal(t, VerdictSkipped, check("unknown").Verdict)
+	assert.Equal(t, VerdictSkipped, ipChecker{}.Check(context.Background(), newTestSubmission()).Verdict)
+}
+
+func TestPipeline_TrustedSkipsContentChecks(t *testing.T) {
+	trusted := &stubChecker{name: "ip", result: CheckResult{Verdict: VerdictPass, Trusted: true}}
+	later := &stubChecker{name: "akismet", result: CheckResult{Verdict: VerdictSpam, SpamScore: 5}}
+	sub := newTestSubmission()
+
+	verdict := NewPipeline([]SpamChecker{trusted, later}, PipelineConfig{AcceptThreshold: 0.3}).Run(context.Background(), sub)
+	assert.False(t, verdict.Rejected())
+	assert.True(t, verdict.AutoAccepted)
+	assert.Equal(t, "ip", verdict.TrustedBy)
+	assert.Equal(t, "trusted by ip check", verdict.Reason())
+	assert.Zero(t, later.calls)
+	require.Len(t, sub.Entry.Checks, 2)
+	assert.Equal(t, VerdictSkipped, sub.Entry.Checks[1].Verdict)
+
+	// Bot checks still run for trusted submissions
+	for _, name := range []string{"honeypot", "formtoken", "recaptcha"} {
+		bot := &stubChecker{name: name, result: CheckResult{Verdict: VerdictSpam, SpamScore: 1}}
+		verdict = NewPipeline([]SpamChecker{trusted, bot}, PipelineConfig{}).Run(context.Background(), newTestSubmission())
+		assert.True(t, verdict.Rejected(), name)
+		assert.Equal(t, name, verdict.DecidedBy)
+	}
+
+	// Shadow checkers cannot trust submissions
+	later.calls = 0
+	verdict = NewPipeline([]SpamChecker{trusted, later}, PipelineConfig{Shadow: []string{"ip"}}).Run(context.Background(), newTestSubmission())
+	assert.True(t, verdict.Rejected())
+	assert.Equal(t, 1, later.calls)
+}
+
+func TestGuestbookSubmission_BlockedIPSkipsRecaptcha(t *testing.T) {
+	server, store, records := newAdminTestServer(t)
+	server.ipBlocklist, _ = parseIPList([]string{"192.0.2.0/24"})
+	verifier := &countingVerifier{}
+	server.captcha = verifier
+
+	jsonData, _ := json.Marshal(map[string]string{
+		"name":                 "Bot",
+		"message":              "Hello",
+		"g-recaptcha-response": "mock-response",
+	})
+	req, err := http.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "192.0.2.55:40000"
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "blocked clients are not told they were blocked")
+	assert.Zero(t, verifier.calls)
+	assert.Empty(t, store.entries)
+	recorded := records.entries[len(records.entries)-1]
+	assert.Equal(t, StatusSpam, recorded.Status)
+	assert.Equal(t, "192.0.2.55", recorded.UserIP)
+}
+
+func TestGuestbookSubmission_AllowlistedIPStillChecksBots(t *testing.T) {
+	server, store, _ := newAdminTestServer(t)
+	server.ipAllowlist, _ = parseIPList([]string{"192.0.2.0/24"})
+	server.config.SpamAcceptThreshold = 0.3
+
+	submit := func(fields map[string]string, verifier CaptchaVerifier) int {
+		server.captcha = verifier
+		jsonData, _ := json.Marshal(fields)
+		req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.0.2.55:40000"
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// The honeypot and captcha catch bots on allowlisted networks
+	submit(map[string]string{"name": "Bot", "message": "Hello", "website": "http://spam.example", "g-recaptcha-response": "mock-response"},
+		&MockRecaptchaVerifier{shouldVerify: true})
+	assert.NotEqual(t, http.StatusOK, submit(map[string]string{"name": "Bot", "message": "Hi", "g-recaptcha-response": "mock-response"},
+		&MockRecaptchaVerifier{shouldVerify: false}))
+	assert.Empty(t, store.entries)
+
+	// Content checks are skipped, so a trusted visitor is published straight away
+	assert.Equal(t, http.StatusOK, submit(map[string]string{"name": "Jane", "message": "Hello there", "g-recaptcha-response": "mock-response"},
+		&MockRecaptchaVerifier{shouldVerify: true}))
+	require.Len(t, store.entries, 1)
+	assert.Equal(t, StatusApproved, store.entries[0].Status)
+}
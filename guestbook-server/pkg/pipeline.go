@@ -0,0 +1,236 @@
+package guestbook_server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Submission is what spam checkers inspect: the raw request and the entry built from it
+type Submission struct {
+	Request GuestbookRequest
+	Entry   *GuestbookEntry
+}
+
+// SpamChecker is one step of the spam-check pipeline
+type SpamChecker interface {
+	// Name identifies the checker in configuration and in recorded check results
+	Name() string
+	Check(ctx context.Context, sub *Submission) CheckResult
+}
+
+// CheckResult is the outcome of one checker
+type CheckResult struct {
+	Verdict string   // VerdictPass, VerdictSpam, VerdictReview, VerdictError or VerdictSkipped
+	Details string   // Recorded with the check result
+	Score   *float64 // Set by checks that report a score, such as reCAPTCHA v3
+	// SpamScore is the weighted evidence this check adds to the submission's
+	// spam score
+	SpamScore float64
+	// Decisive results reject the submission whatever its spam score, e.g.
+	// when a required field is missing
+	Decisive bool
+	// Review holds the submission for manual review even when its spam score
+	// is low enough to auto-accept, e.g. when it links to an unknown domain
+	Review bool
+	// Trusted results skip later content checks, e.g. for allowlisted IPs.
+	// Checks that catch bots still run.
+	Trusted bool
+	// Err is set when the check could not reach a verdict. The checker's
+	// failure policy decides what happens to the submission.
+	Err error
+	// Rejection describes how to turn the submission away when this check
+	// pushes the spam score over the reject threshold, or when Err is set and
+	// the checker fails closed. Defaults are used when it is nil.
+	Rejection *Rejection
+}
+
+// Rejection describes how a submission was turned away
+type Rejection struct {
+	Status EntryStatus // Recorded status, StatusSpam or StatusRejected
+	Reason string      // Recorded reason
+	Code   int         // HTTP status returned to the client
+	// Error is shown to the client. When empty the client is told the
+	// submission was accepted, so bots learn nothing from the rejection.
+	Error   string
+	Details string
+}
+
+// Verdict is the structured outcome of running the pipeline on a submission
+type Verdict struct {
+	Score     float64  // Weighted spam score from the deciding checkers
+	Reasons   []string // Why each checker that did not pass flagged the submission
+	DecidedBy string   // Checker that rejected the submission, empty when accepted
+	Rejection *Rejection
+	Review    []string // Checkers whose failure holds the entry for manual review
+	Held      []string // Why checkers that asked for review flagged the submission
+	TrustedBy string   // Checker that trusted the submission, skipping later content checks
+	// AutoAccepted submissions scored below the accept threshold and can be
+	// published without review
+	AutoAccepted bool
+}
+
+// Rejected reports whether the submission was turned away
+func (v *Verdict) Rejected() bool {
+	return v.Rejection != nil
+}
+
+// Reason summarises the verdict for the entry's recorded reason
+func (v *Verdict) Reason() string {
+	switch {
+	case v.Rejection != nil:
+		return v.Rejection.Reason
+	case len(v.Review) > 0:
+		return fmt.Sprintf("held for review: %s %s failed", strings.Join(v.Review, " and "), pluralize(len(v.Review), "check", "checks"))
+	case len(v.Held) > 0:
+		return "held for review: " + strings.Join(v.Held, "; ")
+	case v.TrustedBy != "":
+		return fmt.Sprintf("trusted by %s check", v.TrustedBy)
+	case v.AutoAccepted:
+		return fmt.Sprintf("auto-accepted with spam score %.2f", v.Score)
+	case v.Score > 0:
+		return fmt.Sprintf("held for review: spam score %.2f (%s)", v.Score, strings.Join(v.Reasons, "; "))
+	default:
+		return "passed all spam checks"
+	}
+}
+
+// contentChecks judge what a submission says rather than how it was sent.
+// They are skipped for trusted submissions; the honeypot, form token and
+// captcha checks are not, since a trusted network can still host bots.
+var contentChecks = map[string]bool{"akismet": true, "heuristics": true, "bayes": true}
+
+// PipelineConfig configures how a pipeline turns check results into a verdict
+type PipelineConfig struct {
+	// Shadow checkers run and have their results recorded, but never decide
+	Shadow []string
+	// Policy returns the failure policy for a checker; fail-closed when nil
+	Policy func(check string) FailurePolicy
+	// AcceptThreshold is the score below which submissions are auto-accepted.
+	// Zero disables auto-accept.
+	AcceptThreshold float64
+	// RejectThreshold is the score at which submissions are rejected,
+	// defaultSpamRejectThreshold when zero
+	RejectThreshold float64
+}
+
+// Pipeline runs spam checkers in order, adding up their weighted spam scores
+// until the submission is rejected or every checker has run
+type Pipeline struct {
+	checkers []SpamChecker
+	shadow   map[string]bool
+	config   PipelineConfig
+}
+
+// NewPipeline builds a pipeline running checkers in order
+func NewPipeline(checkers []SpamChecker, config PipelineConfig) *Pipeline {
+	p := &Pipeline{checkers: checkers, shadow: make(map[string]bool), config: config}
+	for _, name := range config.Shadow {
+		p.shadow[name] = true
+	}
+	if p.config.Policy == nil {
+		p.config.Policy = func(string) FailurePolicy { return FailClosed }
+	}
+	if p.config.RejectThreshold <= 0 {
+		p.config.RejectThreshold = defaultSpamRejectThreshold
+	}
+	return p
+}
+
+// Run checks the submission, recording every checker's result on its entry
+func (p *Pipeline) Run(ctx context.Context, sub *Submission) *Verdict {
+	verdict := &Verdict{}
+	for _, checker := range p.checkers {
+		name := checker.Name()
+		if verdict.TrustedBy != "" && contentChecks[name] {
+			sub.Entry.Checks = append(sub.Entry.Checks, SpamCheckResult{Check: name, Verdict: VerdictSkipped,
+				Details: fmt.Sprintf("trusted by %s check", verdict.TrustedBy)})
+			continue
+		}
+		result := checker.Check(ctx, sub)
+		shadow := p.shadow[name]
+
+		check := SpamCheckResult{
+			Check:     name,
+			Verdict:   result.Verdict,
+			Details:   result.Details,
+			Score:     result.Score,
+			SpamScore: result.SpamScore,
+			Shadow:    shadow,
+		}
+		if result.Err != nil {
+			check.Verdict = VerdictError
+			if check.Details == "" {
+				check.Details = result.Err.Error()
+			}
+			check.Policy = p.config.Policy(name)
+		}
+		sub.Entry.Checks = append(sub.Entry.Checks, check)
+
+		if result.Trusted && !shadow {
+			serverDebugLog("Checker %s trusts submission from %s, skipping later content checks", name, sub.Request.Name)
+			verdict.TrustedBy = name
+			continue
+		}
+
+		if check.Verdict == VerdictPass && check.SpamScore == 0 && !result.Review || check.Verdict == VerdictSkipped {
+			continue
+		}
+		reason := fmt.Sprintf("%s: %s", name, check.Verdict)
+		if check.Details != "" {
+			reason += " (" + check.Details + ")"
+		}
+		if shadow {
+			serverDebugLog("Shadow checker %s flagged submission from %s: %s", name, sub.Request.Name, reason)
+			verdict.Reasons = append(verdict.Reasons, reason+" [shadow]")
+			continue
+		}
+		verdict.Reasons = append(verdict.Reasons, reason)
+
+		if result.Err != nil {
+			switch check.Policy {
+			case FailOpen:
+				serverDebugLog("Checker %s failed, continuing without it: %v", name, result.Err)
+				continue
+			case FailReview:
+				serverDebugLog("Checker %s failed, holding entry for review: %v", name, result.Err)
+				verdict.Review = append(verdict.Review, name)
+				continue
+			}
+			verdict.DecidedBy = name
+			verdict.Rejection = result.Rejection
+			if verdict.Rejection == nil {
+				verdict.Rejection = &Rejection{
+					Status: StatusRejected,
+					Reason: fmt.Sprintf("%s check failed: %v", name, result.Err),
+					Code:   http.StatusServiceUnavailable,
+					Error:  "Spam check unavailable, please try again later",
+				}
+			}
+			return verdict
+		}
+
+		if result.Review {
+			verdict.Held = append(verdict.Held, reason)
+		}
+		verdict.Score += result.SpamScore
+		if !result.Decisive && verdict.Score < p.config.RejectThreshold {
+			continue
+		}
+		verdict.DecidedBy = name
+		switch {
+		case result.Rejection != nil && (result.Decisive || result.SpamScore >= p.config.RejectThreshold):
+			// The check rejects the submission on its own
+			verdict.Rejection = result.Rejection
+		case len(verdict.Reasons) == 1:
+			verdict.Rejection = silentSpam(reason)
+		default:
+			verdict.Rejection = silentSpam(fmt.Sprintf("spam score %.2f (%s)", verdict.Score, strings.Join(verdict.Reasons, "; ")))
+		}
+		return verdict
+	}
+
+	verdict.AutoAccepted = len(verdict.Review) == 0 && len(verdict.Held) == 0 && verdict.Score < p.config.AcceptThreshold
+	return verdict
+}
@@ -0,0 +1,148 @@
+package guestbook_server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubChecker returns a fixed result and counts how often it ran
+type stubChecker struct {
+	name   string
+	result CheckResult
+	calls  int
+}
+
+func (s *stubChecker) Name() string { return s.name }
+
+func (s *stubChecker) Check(ctx context.Context, sub *Submission) CheckResult {
+	s.calls++
+	return s.result
+}
+
+func newTestSubmission() *Submission {
+	return &Submission{Request: GuestbookRequest{Name: "Jane"}, Entry: &GuestbookEntry{Name: "Jane"}}
+}
+
+func TestPipeline_AllPass(t *testing.T) {
+	first := &stubChecker{name: "first", result: CheckResult{Verdict: VerdictPass}}
+	second := &stubChecker{name: "second", result: CheckResult{Verdict: VerdictSkipped}}
+	sub := newTestSubmission()
+
+	verdict := NewPipeline([]SpamChecker{first, second}, PipelineConfig{}).Run(context.Background(), sub)
+	assert.False(t, verdict.Rejected())
+	assert.Empty(t, verdict.DecidedBy)
+	assert.Equal(t, "passed all spam checks", verdict.Reason())
+	require.Len(t, sub.Entry.Checks, 2)
+	assert.Equal(t, "first", sub.Entry.Checks[0].Check)
+	assert.Equal(t, "second", sub.Entry.Checks[1].Check)
+}
+
+func TestPipeline_StopsOnceRejected(t *testing.T) {
+	spam := &stubChecker{name: "spam", result: CheckResult{Verdict: VerdictSpam, Details: "bad words", SpamScore: 1}}
+	later := &stubChecker{name: "later", result: CheckResult{Verdict: VerdictPass}}
+	sub := newTestSubmission()
+
+	verdict := NewPipeline([]SpamChecker{spam, later}, PipelineConfig{}).Run(context.Background(), sub)
+	require.True(t, verdict.Rejected())
+	assert.Equal(t, "spam", verdict.DecidedBy)
+	assert.Equal(t, 1.0, verdict.Score)
+	assert.Equal(t, []string{"spam: spam (bad words)"}, verdict.Reasons)
+	assert.Equal(t, StatusSpam, verdict.Rejection.Status)
+	assert.Equal(t, http.StatusOK, verdict.Rejection.Code, "spam is rejected silently by default")
+	assert.Zero(t, later.calls)
+}
+
+func TestPipeline_ShadowCheckersNeverDecide(t *testing.T) {
+	shadow := &stubChecker{name: "experimental", result: CheckResult{Verdict: VerdictSpam, SpamScore: 5}}
+	later := &stubChecker{name: "later", result: CheckResult{Verdict: VerdictPass}}
+	sub := newTestSubmission()
+
+	verdict := NewPipeline([]SpamChecker{shadow, later}, PipelineConfig{Shadow: []string{"experimental"}}).Run(context.Background(), sub)
+	assert.False(t, verdict.Rejected())
+	assert.Zero(t, verdict.Score)
+	assert.Equal(t, []string{"experimental: spam [shadow]"}, verdict.Reasons)
+	assert.Equal(t, 1, later.calls)
+	require.Len(t, sub.Entry.Checks, 2)
+	assert.True(t, sub.Entry.Checks[0].Shadow)
+	assert.Equal(t, VerdictSpam, sub.Entry.Checks[0].Verdict)
+}
+
+func TestPipeline_FailurePolicies(t *testing.T) {
+	failing := func(name string) *stubChecker {
+		return &stubChecker{name: name, result: CheckResult{Err: errors.New("timeout")}}
+	}
+	policies := map[string]FailurePolicy{"open": FailOpen, "review": FailReview, "closed": FailClosed}
+	policy := func(check string) FailurePolicy { return policies[check] }
+
+	sub := newTestSubmission()
+	verdict := NewPipeline([]SpamChecker{failing("open"), failing("review")}, PipelineConfig{Policy: policy}).Run(context.Background(), sub)
+	assert.False(t, verdict.Rejected())
+	assert.Equal(t, []string{"review"}, verdict.Review)
+	assert.Equal(t, "held for review: review check failed", verdict.Reason())
+	require.Len(t, sub.Entry.Checks, 2)
+	assert.Equal(t, VerdictError, sub.Entry.Checks[0].Verdict)
+	assert.Equal(t, "timeout", sub.Entry.Checks[0].Details)
+	assert.Equal(t, FailOpen, sub.Entry.Checks[0].Policy)
+
+	verdict = NewPipeline([]SpamChecker{failing("closed")}, PipelineConfig{Policy: policy}).Run(context.Background(), newTestSubmission())
+	require.True(t, verdict.Rejected())
+	assert.Equal(t, "closed", verdict.DecidedBy)
+	assert.Zero(t, verdict.Score, "outages are not evidence of spam")
+	assert.Equal(t, StatusRejected, verdict.Rejection.Status)
+	assert.Equal(t, http.StatusServiceUnavailable, verdict.Rejection.Code)
+}
+
+func TestPipeline_WeightedScoring(t *testing.T) {
+	weak := func(name string, score float64) *stubChecker {
+		return &stubChecker{name: name, result: CheckResult{Verdict: VerdictSpam, Details: "weak signal", SpamScore: score}}
+	}
+	clean := &stubChecker{name: "clean", result: CheckResult{Verdict: VerdictPass}}
+	config := PipelineConfig{AcceptThreshold: 0.3, RejectThreshold: 1}
+
+	// One weak signal is held for review instead of being discarded
+	verdict := NewPipeline([]SpamChecker{weak("keyword", 0.4), clean}, config).Run(context.Background(), newTestSubmission())
+	assert.False(t, verdict.Rejected())
+	assert.False(t, verdict.AutoAccepted)
+	assert.InDelta(t, 0.4, verdict.Score, 1e-9)
+	assert.Equal(t, "held for review: spam score 0.40 (keyword: spam (weak signal))", verdict.Reason())
+
+	// Weak signals from several checkers add up to a rejection
+	after := &stubChecker{name: "after", result: CheckResult{Verdict: VerdictPass}}
+	verdict = NewPipeline([]SpamChecker{weak("first", 0.5), weak("second", 0.6), after}, config).Run(context.Background(), newTestSubmission())
+	require.True(t, verdict.Rejected())
+	assert.Equal(t, "second", verdict.DecidedBy)
+	assert.Equal(t, StatusSpam, verdict.Rejection.Status)
+	assert.Contains(t, verdict.Rejection.Reason, "spam score 1.10")
+	assert.Zero(t, after.calls, "checks stop once the submission is rejected")
+
+	// Low scores are auto-accepted
+	verdict = NewPipeline([]SpamChecker{weak("keyword", 0.1), clean}, config).Run(context.Background(), newTestSubmission())
+	assert.True(t, verdict.AutoAccepted)
+	assert.Equal(t, "auto-accepted with spam score 0.10", verdict.Reason())
+
+	// Decisive results reject whatever the score
+	decisive := &stubChecker{name: "required", result: CheckResult{
+		Verdict:   VerdictSpam,
+		Decisive:  true,
+		Rejection: &Rejection{Status: StatusRejected, Reason: "missing field", Code: http.StatusBadRequest, Error: "required"},
+	}}
+	verdict = NewPipeline([]SpamChecker{decisive}, config).Run(context.Background(), newTestSubmission())
+	require.True(t, verdict.Rejected())
+	assert.Equal(t, http.StatusBadRequest, verdict.Rejection.Code)
+}
+
+func TestPipeline_ReviewHoldsLowScores(t *testing.T) {
+	review := &stubChecker{name: "domains", result: CheckResult{Verdict: VerdictReview, Details: "unknown domain example.org", Review: true}}
+	config := PipelineConfig{AcceptThreshold: 0.3, RejectThreshold: 1}
+
+	verdict := NewPipeline([]SpamChecker{review}, config).Run(context.Background(), newTestSubmission())
+	assert.False(t, verdict.Rejected())
+	assert.False(t, verdict.AutoAccepted, "checks asking for review are never auto-accepted")
+	assert.Zero(t, verdict.Score)
+	assert.Equal(t, "held for review: domains: review (unknown domain example.org)", verdict.Reason())
+}
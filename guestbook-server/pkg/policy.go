@@ -0,0 +1,41 @@
+package guestbook_server
+
+import "fmt"
+
+// FailurePolicy decides what happens to a submission when a spam check cannot
+// reach a verdict, for example because its upstream service is down
+type FailurePolicy string
+
+const (
+	FailOpen   FailurePolicy = "fail-open"   // Ignore the failed check
+	FailClosed FailurePolicy = "fail-closed" // Reject the submission
+	FailReview FailurePolicy = "review"      // Accept the submission but hold it for manual review
+)
+
+// defaultFailurePolicies preserves the historical behaviour: reCAPTCHA outages
+// reject submissions while Akismet outages are ignored
+var defaultFailurePolicies = map[string]FailurePolicy{
+	"recaptcha": FailClosed,
+	"akismet":   FailOpen,
+}
+
+// ParseFailurePolicy validates a failure policy name
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch policy := FailurePolicy(s); policy {
+	case FailOpen, FailClosed, FailReview:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown failure policy %q (want fail-open, fail-closed or review)", s)
+}
+
+// failurePolicy returns the configured policy for a check, falling back to the
+// default and then to failing closed
+func (s *Server) failurePolicy(check string) FailurePolicy {
+	if policy, ok := s.config.FailurePolicies[check]; ok && policy != "" {
+		return policy
+	}
+	if policy, ok := defaultFailurePolicies[check]; ok {
+		return policy
+	}
+	return FailClosed
+}
@@ -0,0 +1,114 @@
+package guestbook_server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	for _, name := range []string{"fail-open", "fail-closed", "review"} {
+		policy, err := ParseFailurePolicy(name)
+		require.NoError(t, err)
+		assert.Equal(t, FailurePolicy(name), policy)
+	}
+
+	_, err := ParseFailurePolicy("ignore")
+	assert.Error(t, err)
+}
+
+func TestFailurePolicy_Defaults(t *testing.T) {
+	server := New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 100, RateLimitWindow: 60})
+	assert.Equal(t, FailClosed, server.failurePolicy("recaptcha"))
+	assert.Equal(t, FailOpen, server.failurePolicy("akismet"))
+	assert.Equal(t, FailClosed, server.failurePolicy("unknown"))
+
+	server.config.FailurePolicies = map[string]FailurePolicy{"akismet": FailReview}
+	assert.Equal(t, FailReview, server.failurePolicy("akismet"))
+	assert.Equal(t, FailClosed, server.failurePolicy("recaptcha"))
+}
+
+// submitWithFailingChecks posts a valid submission while Akismet returns errors
+// and reCAPTCHA fails with recaptchaErr (if set)
+func submitWithFailingChecks(t *testing.T, policies map[string]FailurePolicy, recaptchaErr error) (*httptest.ResponseRecorder, *MockEntryStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	akismet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(akismet.Close)
+
+	server := New(&Config{
+		AllowedOrigins:    []string{"*"},
+		RateLimitRequests: 100,
+		RateLimitWindow:   60,
+		FailurePolicies:   policies,
+	})
+	server.akismet = NewAkismetClient("test-key", "https://example.com", WithBaseURL(akismet.URL))
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: recaptchaErr == nil, err: recaptchaErr}
+	records := &MockEntryStore{}
+	server.store = &MockEntryStore{}
+	server.records = records
+
+	form := url.Values{}
+	form.Add("name", "Test User")
+	form.Add("message", "Lovely site")
+	form.Add("g-recaptcha-response", "mock-response")
+	req, err := http.NewRequest("POST", "/guestbook", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr, records
+}
+
+func TestFailurePolicy_AkismetOutage(t *testing.T) {
+	rr, records := submitWithFailingChecks(t, nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, records.entries, 1)
+	assert.Equal(t, StatusPending, records.entries[0].Status)
+	assert.Equal(t, "passed all spam checks", records.entries[0].Reason)
+	assert.Equal(t, FailOpen, records.entries[0].Check("akismet").Policy)
+
+	rr, records = submitWithFailingChecks(t, map[string]FailurePolicy{"akismet": FailClosed}, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Len(t, records.entries, 1)
+	assert.Equal(t, StatusRejected, records.entries[0].Status)
+	assert.Equal(t, FailClosed, records.entries[0].Check("akismet").Policy)
+
+	rr, records = submitWithFailingChecks(t, map[string]FailurePolicy{"akismet": FailReview}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, records.entries, 1)
+	assert.Equal(t, StatusPending, records.entries[0].Status)
+	assert.Equal(t, "held for review: akismet check failed", records.entries[0].Reason)
+}
+
+func TestFailurePolicy_RecaptchaOutage(t *testing.T) {
+	outage := errors.New("connection refused")
+
+	rr, records := submitWithFailingChecks(t, nil, outage)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Len(t, records.entries, 1)
+	assert.Equal(t, StatusRejected, records.entries[0].Status)
+
+	rr, records = submitWithFailingChecks(t, map[string]FailurePolicy{"recaptcha": FailReview, "akismet": FailReview}, outage)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, records.entries, 1)
+	assert.Equal(t, "held for review: recaptcha and akismet checks failed", records.entries[0].Reason)
+
+	check := records.entries[0].Check("recaptcha")
+	require.NotNil(t, check)
+	assert.Equal(t, VerdictError, check.Verdict)
+	assert.Equal(t, "connection refused", check.Details)
+	assert.Equal(t, FailReview, check.Policy)
+
+}
@@ -0,0 +1,173 @@
+package guestbook_server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultPowDifficulty is the number of leading zero bits required of a
+	// solution, about 65,000 hashes or a second or two in a browser
+	defaultPowDifficulty = 16
+	// maxPowDifficulty keeps a misconfiguration from locking everyone out
+	maxPowDifficulty = 32
+	// powChallengeTTL is how long a challenge can be solved and submitted
+	powChallengeTTL = 10 * time.Minute
+)
+
+// ProofOfWork is a self-hosted alternative to reCAPTCHA. GET /challenge
+// issues a signed challenge; the form finds a nonce such that
+// SHA-256(challenge + ":" + nonce) starts with the challenge's number of zero
+// bits, and submits "challenge:nonce" in place of the reCAPTCHA token. It
+// implements CaptchaVerifier, so it slots into the same spam check.
+type ProofOfWork struct {
+	secret     []byte
+	difficulty int
+	ttl        time.Duration
+	nowFunc    func() time.Time
+
+	mu   sync.Mutex
+	used map[string]time.Time // Solved challenges until they expire, to stop replays
+}
+
+// NewProofOfWork creates a verifier signing challenges with secret. An empty
+// secret is replaced by a random one, which invalidates outstanding
+// challenges on restart; servers behind a load balancer must share a secret.
+func NewProofOfWork(secret string, difficulty int) *ProofOfWork {
+	if difficulty <= 0 {
+		difficulty = defaultPowDifficulty
+	}
+	if difficulty > maxPowDifficulty {
+		difficulty = maxPowDifficulty
+	}
+	return &ProofOfWork{
+		secret:     signingKey(secret),
+		difficulty: difficulty,
+		ttl:        powChallengeTTL,
+		nowFunc:    time.Now,
+		used:       make(map[string]time.Time),
+	}
+}
+
+// PowChallenge is the response of GET /challenge
+type PowChallenge struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"` // Leading zero bits required
+	Algorithm  string `json:"algorithm"`
+	ExpiresAt  int64  `json:"expires_at"` // Unix seconds
+}
+
+// sign returns the signature of the challenge payload
+func (p *ProofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewChallenge issues a challenge of the form
+// "<expires>.<difficulty>.<random>.<signature>"
+func (p *ProofOfWork) NewChallenge() (PowChallenge, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return PowChallenge{}, err
+	}
+	expires := p.nowFunc().Add(p.ttl).Unix()
+	payload := fmt.Sprintf("%d.%d.%s", expires, p.difficulty, hex.EncodeToString(random))
+	return PowChallenge{
+		Challenge:  payload + "." + p.sign(payload),
+		Difficulty: p.difficulty,
+		Algorithm:  "SHA-256",
+		ExpiresAt:  expires,
+	}, nil
+}
+
+// Verify checks a "challenge:nonce" solution. Invalid, expired and reused
+// solutions are reported as unverified rather than as errors.
+func (p *ProofOfWork) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	challenge, nonce, ok := strings.Cut(response, ":")
+	if !ok || nonce == "" || len(nonce) > 64 {
+		debugLog("Malformed proof-of-work solution from IP: %s", remoteIP)
+		return false, nil
+	}
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		debugLog("Malformed proof-of-work challenge from IP: %s", remoteIP)
+		return false, nil
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(p.sign(payload))) {
+		debugLog("Proof-of-work challenge with a bad signature from IP: %s", remoteIP)
+		return false, nil
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	now := p.nowFunc()
+	if now.Unix() > expires {
+		debugLog("Expired proof-of-work challenge from IP: %s", remoteIP)
+		return false, nil
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil || difficulty < p.difficulty {
+		return false, nil
+	}
+
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	if leadingZeroBits(sum[:]) < difficulty {
+		debugLog("Proof-of-work solution from IP %s does not meet difficulty %d", remoteIP, difficulty)
+		return false, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for used, until := range p.used {
+		if now.After(until) {
+			delete(p.used, used)
+		}
+	}
+	if _, replayed := p.used[challenge]; replayed {
+		debugLog("Reused proof-of-work challenge from IP: %s", remoteIP)
+		return false, nil
+	}
+	p.used[challenge] = time.Unix(expires, 0)
+	return true, nil
+}
+
+// leadingZeroBits counts the zero bits at the start of b
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
+
+// handleChallenge issues a proof-of-work challenge
+func (s *Server) handleChallenge(c *gin.Context) {
+	challenge, err := s.pow.NewChallenge()
+	if err != nil {
+		log.Printf("Failed to create proof-of-work challenge: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create challenge"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, challenge)
+}
@@ -0,0 +1,151 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// solvePow finds a nonce for challenge the way the form does
+func solvePow(t *testing.T, challenge string, difficulty int) string {
+	t.Helper()
+	for nonce := 0; nonce < 1<<24; nonce++ {
+		candidate := challenge + ":" + strconv.Itoa(nonce)
+		sum := sha256.Sum256([]byte(candidate))
+		if leadingZeroBits(sum[:]) >= difficulty {
+			return candidate
+		}
+	}
+	t.Fatal("no proof-of-work solution found")
+	return ""
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	assert.Equal(t, 0, leadingZeroBits([]byte{0x80}))
+	assert.Equal(t, 7, leadingZeroBits([]byte{0x01}))
+	assert.Equal(t, 12, leadingZeroBits([]byte{0x00, 0x0f}))
+	assert.Equal(t, 16, leadingZeroBits([]byte{0x00, 0x00}))
+}
+
+func TestProofOfWork_Verify(t *testing.T) {
+	pow := NewProofOfWork("secret", 8)
+	ctx := context.Background()
+
+	challenge, err := pow.NewChallenge()
+	require.NoError(t, err)
+	assert.Equal(t, 8, challenge.Difficulty)
+	solution := solvePow(t, challenge.Challenge, challenge.Difficulty)
+
+	valid, err := pow.Verify(ctx, solution, "192.0.2.1")
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	valid, _ = pow.Verify(ctx, solution, "192.0.2.1")
+	assert.False(t, valid, "solutions cannot be replayed")
+
+	for name, response := range map[string]string{
+		"empty":     "",
+		"no nonce":  challenge.Challenge,
+		"malformed": "abc:1",
+		"forged":    strings.Replace(solution, ".8.", ".1.", 1),
+	} {
+		valid, err := pow.Verify(ctx, response, "192.0.2.1")
+		assert.NoError(t, err, name)
+		assert.False(t, valid, name)
+	}
+
+	// Challenges signed with another secret are rejected
+	other, _ := NewProofOfWork("other", 8).NewChallenge()
+	valid, _ = pow.Verify(ctx, solvePow(t, other.Challenge, 8), "192.0.2.1")
+	assert.False(t, valid)
+}
+
+func TestProofOfWork_RejectsInsufficientWork(t *testing.T) {
+	pow := NewProofOfWork("secret", 8)
+	challenge, err := pow.NewChallenge()
+	require.NoError(t, err)
+
+	for nonce := 0; ; nonce++ {
+		candidate := challenge.Challenge + ":" + strconv.Itoa(nonce)
+		sum := sha256.Sum256([]byte(candidate))
+		if leadingZeroBits(sum[:]) < 8 {
+			valid, _ := pow.Verify(context.Background(), candidate, "192.0.2.1")
+			assert.False(t, valid)
+			return
+		}
+	}
+}
+
+func TestProofOfWork_Expiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pow := NewProofOfWork("secret", 4)
+	pow.nowFunc = func() time.Time { return now }
+
+	challenge, err := pow.NewChallenge()
+	require.NoError(t, err)
+	solution := solvePow(t, challenge.Challenge, challenge.Difficulty)
+
+	now = now.Add(powChallengeTTL + time.Second)
+	valid, _ := pow.Verify(context.Background(), solution, "192.0.2.1")
+	assert.False(t, valid)
+}
+
+func TestNewProofOfWork_Defaults(t *testing.T) {
+	pow := NewProofOfWork("", 0)
+	assert.Len(t, pow.secret, 32)
+	assert.Equal(t, defaultPowDifficulty, pow.difficulty)
+	assert.Equal(t, maxPowDifficulty, NewProofOfWork("", 100).difficulty)
+}
+
+func TestGuestbookSubmission_ProofOfWork(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{
+		AllowedOrigins:        []string{"*"},
+		RateLimitRequests:     100,
+		RateLimitWindow:       60,
+		CaptchaProvider:       "pow",
+		ProofOfWorkDifficulty: 8,
+	})
+	store := &MockEntryStore{}
+	server.store = store
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/challenge", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var challenge PowChallenge
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &challenge))
+	assert.Equal(t, 8, challenge.Difficulty)
+	assert.Equal(t, "SHA-256", challenge.Algorithm)
+
+	jsonData, _ := json.Marshal(map[string]string{
+		"name":         "Test User",
+		"message":      "Hello without Google",
+		"pow-response": solvePow(t, challenge.Challenge, challenge.Difficulty),
+	})
+	req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, store.entries, 1)
+}
+
+func TestChallengeRoute_OnlyWithProofOfWork(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{RateLimitRequests: 100, RateLimitWindow: 60})
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/challenge", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,77 @@
+package guestbook_server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustedPlatforms maps platform names to the header their edge sets to
+// the client address
+var trustedPlatforms = map[string]string{
+	"cloudflare":        gin.PlatformCloudflare,
+	"google-app-engine": gin.PlatformGoogleAppEngine,
+	"flyio":             gin.PlatformFlyIO,
+}
+
+// defaultRemoteIPHeaders are read from trusted proxies when
+// Config.RemoteIPHeaders is empty
+var defaultRemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// platformHeader resolves a TrustedPlatform setting, which is a platform
+// name or the header itself
+func platformHeader(platform string) string {
+	platform = strings.TrimSpace(platform)
+	if header, ok := trustedPlatforms[strings.ToLower(platform)]; ok {
+		return header
+	}
+	return platform
+}
+
+// configureClientIP sets which proxies and headers c.ClientIP() trusts.
+// Without trusted proxies the connection's address is used, since gin
+// otherwise believes X-Forwarded-For from anyone. On error no proxies are
+// trusted.
+func configureClientIP(router *gin.Engine, config *Config) error {
+	router.TrustedPlatform = platformHeader(config.TrustedPlatform)
+	router.RemoteIPHeaders = defaultRemoteIPHeaders
+	if len(config.RemoteIPHeaders) > 0 {
+		router.RemoteIPHeaders = config.RemoteIPHeaders
+	}
+
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		router.SetTrustedProxies(nil)
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	return nil
+}
+
+// clientIPLogger logs where the client address of a request came from when
+// it was not the connection's own address. Without trusted proxies, it warns
+// once when a request arrives through a proxy anyway, since every client
+// then shares the proxy's address.
+func clientIPLogger(config *Config) gin.HandlerFunc {
+	trusting := len(config.TrustedProxies) > 0 || config.TrustedPlatform != ""
+	var warn sync.Once
+	return func(c *gin.Context) {
+		remote, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil {
+			remote = c.Request.RemoteAddr
+		}
+		if ip := c.ClientIP(); ip != remote {
+			serverDebugLog("Resolved client IP %s for request from %s", ip, remote)
+		}
+		if !trusting && c.GetHeader("X-Forwarded-For") != "" {
+			warn.Do(func() {
+				log.Printf("WARNING: request from %s carries X-Forwarded-For but no trusted proxies are configured; "+
+					"all clients behind this proxy share its IP for rate limits and spam checks. "+
+					"Set TRUSTED_PROXIES (169.254.0.0/16 on Cloud Run) or TRUSTED_PLATFORM", remote)
+			})
+		}
+		c.Next()
+	}
+}
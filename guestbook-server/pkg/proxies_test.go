@@ -0,0 +1,139 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resolveClientIP returns the client IP the router resolves for a request
+// from remoteAddr with the given headers
+func resolveClientIP(t *testing.T, config *Config, remoteAddr string, headers map[string]string) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	require.NoError(t, configureClientIP(router, config))
+
+	var ip string
+	router.GET("/", func(c *gin.Context) { ip = c.ClientIP() })
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr + ":12345"
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	return ip
+}
+
+func TestConfigureClientIP_NoProxies(t *testing.T) {
+	ip := resolveClientIP(t, &Config{}, "203.0.113.7", map[string]string{
+		"X-Forwarded-For":  "192.0.2.1",
+		"X-Real-IP":        "192.0.2.2",
+		"CF-Connecting-IP": "192.0.2.3",
+	})
+	assert.Equal(t, "203.0.113.7", ip, "forwarded headers are ignored by default")
+}
+
+func TestConfigureClientIP_TrustedProxies(t *testing.T) {
+	config := &Config{TrustedProxies: []string{"10.0.0.0/8"}}
+	headers := map[string]string{"X-Forwarded-For": "192.0.2.1, 10.1.2.3"}
+
+	assert.Equal(t, "192.0.2.1", resolveClientIP(t, config, "10.0.0.1", headers))
+	assert.Equal(t, "203.0.113.7", resolveClientIP(t, config, "203.0.113.7", headers),
+		"clients connecting directly cannot spoof their address")
+	assert.Equal(t, "192.0.2.2", resolveClientIP(t, config, "10.0.0.1", map[string]string{"X-Real-IP": "192.0.2.2"}))
+
+	config.RemoteIPHeaders = []string{"X-Client-IP"}
+	assert.Equal(t, "10.0.0.1", resolveClientIP(t, config, "10.0.0.1", headers))
+	assert.Equal(t, "192.0.2.4", resolveClientIP(t, config, "10.0.0.1", map[string]string{"X-Client-IP": "192.0.2.4"}))
+}
+
+func TestConfigureClientIP_Platform(t *testing.T) {
+	for platform, header := range map[string]string{
+		"cloudflare":  "CF-Connecting-IP",
+		"Flyio":       "Fly-Client-IP",
+		"X-Client-IP": "X-Client-IP",
+	} {
+		ip := resolveClientIP(t, &Config{TrustedPlatform: platform}, "203.0.113.7", map[string]string{
+			header:            "192.0.2.1",
+			"X-Forwarded-For": "192.0.2.9",
+		})
+		assert.Equal(t, "192.0.2.1", ip, platform)
+	}
+}
+
+func TestConfigureClientIP_InvalidProxies(t *testing.T) {
+	router := gin.New()
+	assert.Error(t, configureClientIP(router, &Config{TrustedProxies: []string{"not-a-cidr"}}))
+
+	var ip string
+	router.GET("/", func(c *gin.Context) { ip = c.ClientIP() })
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.7:12345"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "203.0.113.7", ip, "no proxies are trusted after an error")
+}
+
+func TestRateLimitMiddleware_SpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{RateLimitRequests: 1, RateLimitWindow: 60})
+	defer server.Close()
+
+	for i, forwarded := range []string{"192.0.2.1", "198.51.100.1"} {
+		req := httptest.NewRequest("POST", "/guestbook", nil)
+		req.RemoteAddr = "203.0.113.7:12345"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if i == 0 {
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, rr.Code, "a new X-Forwarded-For does not reset the limit")
+		}
+	}
+}
+
+func TestConfigureClientIP_CloudRun(t *testing.T) {
+	config := &Config{TrustedProxies: []string{"169.254.0.0/16"}}
+	ip := resolveClientIP(t, config, "169.254.169.126", map[string]string{"X-Forwarded-For": "203.0.113.9, 192.0.2.1"})
+	assert.Equal(t, "192.0.2.1", ip, "the address appended by Google's front end is used, not one sent by the client")
+}
+
+func TestClientIPLogger_WarnsWithoutTrustedProxies(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	send := func(config *Config, forwarded string) {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		require.NoError(t, configureClientIP(router, config))
+		router.Use(clientIPLogger(config))
+		router.GET("/", func(c *gin.Context) {})
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "169.254.169.126:12345"
+			if forwarded != "" {
+				req.Header.Set("X-Forwarded-For", forwarded)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	send(&Config{}, "")
+	assert.Empty(t, buf.String(), "direct connections are fine")
+	send(&Config{TrustedProxies: []string{"169.254.0.0/16"}}, "192.0.2.1")
+	assert.Empty(t, buf.String())
+
+	send(&Config{}, "192.0.2.1")
+	assert.Equal(t, 1, strings.Count(buf.String(), "WARNING"), "the warning is logged once")
+	assert.Contains(t, buf.String(), "TRUSTED_PROXIES")
+}
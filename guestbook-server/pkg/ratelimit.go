@@ -0,0 +1,458 @@
+package guestbook_server
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// defaultRateLimitMaxClients caps how many clients' limiters are kept, a few
+// tens of megabytes at most
+const defaultRateLimitMaxClients = 100000
+
+// limiterStore holds a token bucket per client. Limiters idle for longer
+// than ttl are evicted, since their bucket has refilled and a new one would
+// behave the same. Beyond maxEntries the least recently used limiter is
+// evicted, so a flood from many addresses cannot grow memory without bound.
+type limiterStore struct {
+	limit      rate.Limit
+	burst      int
+	ttl        time.Duration
+	maxEntries int
+	nowFunc    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Of *limiterEntry, most recently used first
+}
+
+type limiterEntry struct {
+	key        string
+	limiter    *rate.Limiter
+	lastAccess time.Time
+}
+
+// newLimiterStore creates a store allowing requests per window for each
+// client. No more than maxEntries limiters are kept, or
+// defaultRateLimitMaxClients when zero.
+func newLimiterStore(requests int, window time.Duration, maxEntries int) *limiterStore {
+	if maxEntries <= 0 {
+		maxEntries = defaultRateLimitMaxClients
+	}
+	limit, burst := rate.Inf, 1
+	if requests > 0 && window > 0 {
+		limit, burst = rate.Every(window/time.Duration(requests)), requests
+	}
+	return &limiterStore{
+		limit:      limit,
+		burst:      burst,
+		ttl:        window,
+		maxEntries: maxEntries,
+		nowFunc:    time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Get returns the limiter for key, creating it if needed
+func (l *limiterStore) Get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.nowFunc()
+	if elem, ok := l.entries[key]; ok {
+		entry := elem.Value.(*limiterEntry)
+		entry.lastAccess = now
+		l.lru.MoveToFront(elem)
+		return entry.limiter
+	}
+
+	l.evictIdle(now)
+	for l.lru.Len() >= l.maxEntries {
+		l.remove(l.lru.Back())
+	}
+	entry := &limiterEntry{key: key, limiter: rate.NewLimiter(l.limit, l.burst), lastAccess: now}
+	l.entries[key] = l.lru.PushFront(entry)
+	return entry.limiter
+}
+
+// RateLimitStatus is a client's allowance after a request
+type RateLimitStatus struct {
+	Allowed    bool
+	Limit      int           // Requests allowed in a burst
+	Remaining  int           // Requests left in the current burst
+	RetryAfter time.Duration // Until the next request is allowed, when not Allowed
+	Reset      time.Duration // Until the full allowance is restored
+}
+
+// Take uses up one request of key's allowance, if any is left
+func (l *limiterStore) Take(key string) RateLimitStatus {
+	limiter := l.Get(key)
+	now := l.nowFunc()
+	status := RateLimitStatus{Limit: l.burst, Allowed: true}
+
+	reservation := limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		status.Allowed = false
+		status.RetryAfter = delay
+	}
+	tokens := limiter.TokensAt(now)
+	status.Remaining = int(max(tokens, 0))
+	if l.limit != rate.Inf {
+		status.Reset = time.Duration((float64(l.burst) - tokens) / float64(l.limit) * float64(time.Second))
+	}
+	return status
+}
+
+// Allow reports whether key may make a request now
+func (l *limiterStore) Allow(key string) bool {
+	return l.Take(key).Allowed
+}
+
+// Len returns the number of limiters held
+func (l *limiterStore) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
+
+// Cleanup evicts idle limiters and returns how many were removed
+func (l *limiterStore) Cleanup() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.evictIdle(l.nowFunc())
+}
+
+// evictIdle removes limiters unused for longer than the TTL. They are found
+// at the back of the list, which is ordered by last access.
+func (l *limiterStore) evictIdle(now time.Time) int {
+	evicted := 0
+	for elem := l.lru.Back(); elem != nil; elem = l.lru.Back() {
+		if now.Sub(elem.Value.(*limiterEntry).lastAccess) <= l.ttl {
+			break
+		}
+		l.remove(elem)
+		evicted++
+	}
+	return evicted
+}
+
+func (l *limiterStore) remove(elem *list.Element) {
+	l.lru.Remove(elem)
+	delete(l.entries, elem.Value.(*limiterEntry).key)
+}
+
+// RateLimitPolicy limits how often each client may call a route
+type RateLimitPolicy struct {
+	Requests int // Requests allowed per window; 0 means unlimited
+	Window   int // Seconds, Config.RateLimitWindow when 0
+}
+
+// defaultRateLimitPolicies exempt routes from Config.RateLimitRequests, which
+// applies to routes without a policy of their own
+var defaultRateLimitPolicies = map[string]RateLimitPolicy{
+	"GET /health": {}, // Polled by load balancers and uptime checks
+}
+
+// ParseRateLimitPolicy parses "off" or "<requests>/<seconds>", e.g. "5/600"
+func ParseRateLimitPolicy(value string) (RateLimitPolicy, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return RateLimitPolicy{}, nil
+	}
+	requests, window, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimitPolicy{}, fmt.Errorf("rate limit %q is not off or <requests>/<seconds>", value)
+	}
+	var policy RateLimitPolicy
+	var err error
+	if policy.Requests, err = strconv.Atoi(requests); err != nil || policy.Requests < 0 {
+		return RateLimitPolicy{}, fmt.Errorf("rate limit %q has invalid requests", value)
+	}
+	if policy.Window, err = strconv.Atoi(window); err != nil || policy.Window <= 0 {
+		return RateLimitPolicy{}, fmt.Errorf("rate limit %q has an invalid window", value)
+	}
+	return policy, nil
+}
+
+// RateLimitBackend keeps the state of rate limits. The in-memory default is
+// per process; a shared backend such as Redis makes replicas behind a load
+// balancer enforce one limit together, and keeps it across restarts.
+type RateLimitBackend interface {
+	// Take uses up one of the requests key may make per window, if any is left
+	Take(ctx context.Context, key string, requests int, window time.Duration) (RateLimitStatus, error)
+}
+
+// MemoryRateLimitBackend keeps token buckets in process memory
+type MemoryRateLimitBackend struct {
+	maxEntries int
+	nowFunc    func() time.Time
+
+	mu     sync.Mutex
+	stores map[memoryRateLimit]*limiterStore
+}
+
+// memoryRateLimit identifies the store of the buckets sharing a limit
+type memoryRateLimit struct {
+	requests int
+	window   time.Duration
+}
+
+// NewMemoryRateLimitBackend creates a backend tracking at most maxEntries
+// keys per limit, or defaultRateLimitMaxClients when zero
+func NewMemoryRateLimitBackend(maxEntries int) *MemoryRateLimitBackend {
+	return &MemoryRateLimitBackend{
+		maxEntries: maxEntries,
+		nowFunc:    time.Now,
+		stores:     make(map[memoryRateLimit]*limiterStore),
+	}
+}
+
+func (m *MemoryRateLimitBackend) Take(ctx context.Context, key string, requests int, window time.Duration) (RateLimitStatus, error) {
+	limit := memoryRateLimit{requests: requests, window: window}
+	m.mu.Lock()
+	store, ok := m.stores[limit]
+	if !ok {
+		store = newLimiterStore(requests, window, m.maxEntries)
+		store.nowFunc = m.nowFunc
+		m.stores[limit] = store
+	}
+	m.mu.Unlock()
+	return store.Take(key), nil
+}
+
+// Len returns the number of buckets held
+func (m *MemoryRateLimitBackend) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, store := range m.stores {
+		n += store.Len()
+	}
+	return n
+}
+
+// Cleanup evicts idle buckets and returns how many were removed
+func (m *MemoryRateLimitBackend) Cleanup() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	evicted := 0
+	for _, store := range m.stores {
+		evicted += store.Cleanup()
+	}
+	return evicted
+}
+
+// Run evicts idle buckets every interval until ctx is cancelled
+func (m *MemoryRateLimitBackend) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if evicted := m.Cleanup(); evicted > 0 {
+				serverDebugLog("Evicted %d idle rate limiters, %d remain", evicted, m.Len())
+			}
+		}
+	}
+}
+
+// newRateLimitBackend creates the backend named by Config.RateLimitBackend
+func newRateLimitBackend(config *Config) (RateLimitBackend, error) {
+	switch config.RateLimitBackend {
+	case "", "memory":
+		return NewMemoryRateLimitBackend(config.RateLimitMaxClients), nil
+	case "redis":
+		if config.RedisURL == "" {
+			return nil, fmt.Errorf("the redis rate limit backend requires a Redis URL")
+		}
+		options, err := redis.ParseURL(config.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis URL: %w", err)
+		}
+		return NewRedisRateLimitBackend(redis.NewClient(options), ""), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", config.RateLimitBackend)
+	}
+}
+
+const (
+	// defaultRateLimitIPv4Prefix and defaultRateLimitIPv6Prefix group clients
+	// by the smallest block usually assigned to one customer, so an attacker
+	// cannot reset their limit by moving to a neighbouring address
+	defaultRateLimitIPv4Prefix = 24
+	defaultRateLimitIPv6Prefix = 64
+	// globalRateLimitKey is the key of the global submission limit
+	globalRateLimitKey = "global submissions"
+	// rateLimitBackendTimeout bounds how long a shared backend can delay a
+	// request
+	rateLimitBackendTimeout = 500 * time.Millisecond
+)
+
+// rateLimit is a policy with its window resolved
+type rateLimit struct {
+	requests int
+	window   time.Duration
+}
+
+// rateLimits applies the rate limit policies of each route
+type rateLimits struct {
+	backend  RateLimitBackend
+	fallback *rateLimit            // Routes without a policy of their own
+	routes   map[string]*rateLimit // By "METHOD /path"; nil for unlimited routes
+	// global limits submissions from all clients together, nil when disabled
+	global     *rateLimit
+	ipv4Prefix int
+	ipv6Prefix int
+}
+
+func newRateLimits(config *Config, backend RateLimitBackend) *rateLimits {
+	window := time.Duration(config.RateLimitWindow) * time.Second
+	resolve := func(policy RateLimitPolicy) *rateLimit {
+		if policy.Requests <= 0 {
+			return nil
+		}
+		limit := &rateLimit{requests: policy.Requests, window: window}
+		if policy.Window > 0 {
+			limit.window = time.Duration(policy.Window) * time.Second
+		}
+		if limit.window <= 0 {
+			return nil
+		}
+		return limit
+	}
+
+	limits := &rateLimits{
+		backend:    backend,
+		fallback:   resolve(RateLimitPolicy{Requests: config.RateLimitRequests}),
+		routes:     make(map[string]*rateLimit),
+		ipv4Prefix: config.RateLimitIPv4Prefix,
+		ipv6Prefix: config.RateLimitIPv6Prefix,
+	}
+	for route, policy := range defaultRateLimitPolicies {
+		limits.routes[route] = resolve(policy)
+	}
+	for route, policy := range config.RateLimitPolicies {
+		limits.routes[route] = resolve(policy)
+	}
+	if config.RateLimitGlobalHourly > 0 {
+		limits.global = &rateLimit{requests: config.RateLimitGlobalHourly, window: time.Hour}
+	}
+	if limits.ipv4Prefix <= 0 || limits.ipv4Prefix > 32 {
+		limits.ipv4Prefix = defaultRateLimitIPv4Prefix
+	}
+	if limits.ipv6Prefix <= 0 || limits.ipv6Prefix > 128 {
+		limits.ipv6Prefix = defaultRateLimitIPv6Prefix
+	}
+	return limits
+}
+
+// forRoute returns the limit of a route, or nil when it is unlimited
+func (r *rateLimits) forRoute(route string) *rateLimit {
+	if limit, ok := r.routes[route]; ok {
+		return limit
+	}
+	return r.fallback
+}
+
+// take uses up one request of key's allowance under limit. Backend errors
+// are logged and the request allowed, since an outage of shared state
+// should not take the guestbook down with it.
+func (r *rateLimits) take(ctx context.Context, key string, limit *rateLimit) RateLimitStatus {
+	ctx, cancel := context.WithTimeout(ctx, rateLimitBackendTimeout)
+	defer cancel()
+	status, err := r.backend.Take(ctx, key, limit.requests, limit.window)
+	if err != nil {
+		log.Printf("Rate limit backend failed, allowing request: %v", err)
+		return RateLimitStatus{Allowed: true, Limit: limit.requests, Remaining: limit.requests}
+	}
+	return status
+}
+
+// clientKey groups an IP with the others in its /24 or /64, so they share
+// one limit
+func (r *rateLimits) clientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := r.ipv6Prefix
+	if addr.Is4() {
+		bits = r.ipv4Prefix
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+// setRateLimitHeaders describes the client's allowance in the RateLimit
+// headers of the IETF draft, and when to retry if it is used up
+func setRateLimitHeaders(c *gin.Context, status RateLimitStatus, window time.Duration) {
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", status.Limit, int(window/time.Second)))
+	c.Header("RateLimit-Limit", strconv.Itoa(status.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(status.Reset)))
+	if !status.Allowed {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(status.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+func (s *Server) rateLimitMiddleware(c *gin.Context) {
+	// CORS preflights are answered before this runs, and are not limited
+	route := c.Request.Method + " " + c.FullPath()
+	limit := s.rateLimits.forRoute(route)
+	if limit == nil {
+		c.Next()
+		return
+	}
+
+	ctx := c.Request.Context()
+	status := s.rateLimits.take(ctx, route+" "+s.rateLimits.clientKey(c.ClientIP()), limit)
+	setRateLimitHeaders(c, status, limit.window)
+	if !status.Allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// allowGlobalSubmission charges an accepted submission to the global hourly
+// ceiling, and answers 429 once it is used up. It is called after the spam
+// checks, so junk from a single bot cannot use up everyone's allowance.
+func (s *Server) allowGlobalSubmission(c *gin.Context) bool {
+	global := s.rateLimits.global
+	if global == nil {
+		return true
+	}
+	status := s.rateLimits.take(c.Request.Context(), globalRateLimitKey, global)
+	if status.Allowed {
+		return true
+	}
+	log.Printf("Global submission rate limit of %d per hour reached", status.Limit)
+	c.Header("Retry-After", strconv.Itoa(ceilSeconds(status.RetryAfter)))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many submissions right now, please try again later"})
+	return false
+}
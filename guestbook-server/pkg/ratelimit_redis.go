@@ -0,0 +1,87 @@
+package guestbook_server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultRedisKeyPrefix namespaces the keys written to a shared Redis
+const defaultRedisKeyPrefix = "guestbook:ratelimit:"
+
+// gcraScript implements the generic cell rate algorithm, which behaves like
+// the in-memory token buckets but stores a single timestamp per key: the
+// theoretical arrival time (TAT) at which the bucket is full again. A
+// request is allowed unless it would push the TAT more than the burst ahead
+// of now. The time is passed in by the caller, since scripts cannot read the
+// clock. Returns {allowed, tat} in milliseconds.
+var gcraScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local new_tat = tat + interval
+if new_tat - burst * interval > now then
+	return {0, tat}
+end
+redis.call("SET", KEYS[1], new_tat, "PX", math.max(1, math.ceil(new_tat - now)))
+return {1, new_tat}
+`)
+
+// RedisRateLimitBackend keeps rate limits in Redis, or anything speaking its
+// protocol, so replicas share them and they survive restarts
+type RedisRateLimitBackend struct {
+	client  *redis.Client
+	prefix  string
+	nowFunc func() time.Time
+}
+
+// NewRedisRateLimitBackend creates a backend storing keys under prefix, or
+// defaultRedisKeyPrefix when empty
+func NewRedisRateLimitBackend(client *redis.Client, prefix string) *RedisRateLimitBackend {
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+	return &RedisRateLimitBackend{client: client, prefix: prefix, nowFunc: time.Now}
+}
+
+func (r *RedisRateLimitBackend) Take(ctx context.Context, key string, requests int, window time.Duration) (RateLimitStatus, error) {
+	now := r.nowFunc().UnixMilli()
+	interval := window.Milliseconds() / int64(requests)
+	if interval < 1 {
+		interval = 1
+	}
+
+	result, err := gcraScript.Run(ctx, r.client, []string{r.prefix + key}, now, interval, requests).Int64Slice()
+	if err != nil {
+		return RateLimitStatus{}, fmt.Errorf("redis rate limit: %w", err)
+	}
+	if len(result) != 2 {
+		return RateLimitStatus{}, fmt.Errorf("redis rate limit: unexpected script result %v", result)
+	}
+	allowed, tat := result[0] == 1, result[1]
+
+	status := RateLimitStatus{
+		Allowed: allowed,
+		Limit:   requests,
+		// Each request still allowed moves the TAT one interval further
+		Remaining: int((now + int64(requests)*interval - tat) / interval),
+		Reset:     time.Duration(tat-now) * time.Millisecond,
+	}
+	if !allowed {
+		status.RetryAfter = time.Duration(tat+interval-int64(requests)*interval-now) * time.Millisecond
+	}
+	status.Remaining = max(0, min(status.Remaining, requests))
+	return status, nil
+}
+
+// Close closes the connection to Redis
+func (r *RedisRateLimitBackend) Close() error {
+	return r.client.Close()
+}
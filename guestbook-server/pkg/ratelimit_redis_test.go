@@ -0,0 +1,118 @@
+package guestbook_server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisBackend(t *testing.T) (*RedisRateLimitBackend, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	backend := NewRedisRateLimitBackend(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+	t.Cleanup(func() { backend.Close() })
+	return backend, mr
+}
+
+func TestRedisRateLimitBackend_Take(t *testing.T) {
+	backend, mr := newTestRedisBackend(t)
+	now := time.Unix(1700000000, 0)
+	backend.nowFunc = func() time.Time { return now }
+	ctx := context.Background()
+
+	status, err := backend.Take(ctx, "192.0.2.0/24", 3, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, RateLimitStatus{Allowed: true, Limit: 3, Remaining: 2, Reset: 20 * time.Second}, status)
+	assert.True(t, mr.Exists(defaultRedisKeyPrefix+"192.0.2.0/24"))
+
+	backend.Take(ctx, "192.0.2.0/24", 3, time.Minute)
+	status, _ = backend.Take(ctx, "192.0.2.0/24", 3, time.Minute)
+	assert.True(t, status.Allowed)
+	assert.Zero(t, status.Remaining)
+	assert.Equal(t, time.Minute, status.Reset)
+
+	status, _ = backend.Take(ctx, "192.0.2.0/24", 3, time.Minute)
+	assert.False(t, status.Allowed)
+	assert.Equal(t, 20*time.Second, status.RetryAfter)
+
+	status, _ = backend.Take(ctx, "198.51.100.0/24", 3, time.Minute)
+	assert.True(t, status.Allowed, "keys are limited separately")
+
+	now = now.Add(20 * time.Second)
+	status, _ = backend.Take(ctx, "192.0.2.0/24", 3, time.Minute)
+	assert.True(t, status.Allowed, "denied requests do not use up the allowance")
+}
+
+func TestRedisRateLimitBackend_SharedBetweenReplicas(t *testing.T) {
+	first, mr := newTestRedisBackend(t)
+	second := NewRedisRateLimitBackend(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+	defer second.Close()
+	ctx := context.Background()
+
+	status, err := first.Take(ctx, "POST /guestbook 192.0.2.0/24", 2, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	status, _ = second.Take(ctx, "POST /guestbook 192.0.2.0/24", 2, time.Minute)
+	assert.True(t, status.Allowed)
+	status, _ = first.Take(ctx, "POST /guestbook 192.0.2.0/24", 2, time.Minute)
+	assert.False(t, status.Allowed, "both replicas draw on one allowance")
+
+	// Keys expire once the bucket is full again
+	mr.FastForward(time.Minute + time.Second)
+	assert.False(t, mr.Exists(defaultRedisKeyPrefix+"POST /guestbook 192.0.2.0/24"))
+}
+
+func TestRedisRateLimitBackend_Unavailable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	backend := NewRedisRateLimitBackend(redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1}), "")
+	defer backend.Close()
+	mr.Close()
+
+	_, err := backend.Take(context.Background(), "192.0.2.0/24", 3, time.Minute)
+	assert.Error(t, err)
+
+	limits := newRateLimits(&Config{RateLimitRequests: 1, RateLimitWindow: 60}, backend)
+	for i := 0; i < 3; i++ {
+		assert.True(t, limits.take(context.Background(), "192.0.2.0/24", limits.fallback).Allowed, "requests are allowed while Redis is down")
+	}
+}
+
+func TestNewRateLimitBackend(t *testing.T) {
+	backend, err := newRateLimitBackend(&Config{})
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryRateLimitBackend{}, backend)
+
+	_, err = newRateLimitBackend(&Config{RateLimitBackend: "redis"})
+	assert.ErrorContains(t, err, "requires a Redis URL")
+	_, err = newRateLimitBackend(&Config{RateLimitBackend: "memcached"})
+	assert.ErrorContains(t, err, "unknown rate limit backend")
+
+	mr := miniredis.RunT(t)
+	backend, err = newRateLimitBackend(&Config{RateLimitBackend: "redis", RedisURL: "redis://" + mr.Addr() + "/0"})
+	require.NoError(t, err)
+	require.IsType(t, &RedisRateLimitBackend{}, backend)
+	assert.NoError(t, backend.(*RedisRateLimitBackend).Close())
+}
+
+func TestRateLimitMiddleware_Redis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	config := &Config{RateLimitRequests: 1, RateLimitWindow: 60, RateLimitBackend: "redis", RedisURL: "redis://" + mr.Addr()}
+
+	// Two replicas sharing one Redis
+	first, second := New(config), New(config)
+	defer first.Close()
+	defer second.Close()
+
+	assert.Equal(t, http.StatusBadRequest, rateLimitRequest(first, "POST", "/guestbook", "192.0.2.1").Code)
+	rr := rateLimitRequest(second, "POST", "/guestbook", "192.0.2.2")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+}
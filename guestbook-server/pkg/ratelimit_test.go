@@ -0,0 +1,289 @@
+package guestbook_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterStore_Allow(t *testing.T) {
+	store := newLimiterStore(2, time.Minute, 0)
+	assert.True(t, store.Allow("192.0.2.1"))
+	assert.True(t, store.Allow("192.0.2.1"))
+	assert.False(t, store.Allow("192.0.2.1"), "the burst is used up")
+	assert.True(t, store.Allow("192.0.2.2"), "clients are limited separately")
+	assert.Same(t, store.Get("192.0.2.1"), store.Get("192.0.2.1"))
+
+	unlimited := newLimiterStore(0, 0, 0)
+	for i := 0; i < 100; i++ {
+		assert.True(t, unlimited.Allow("192.0.2.1"))
+	}
+}
+
+func TestLimiterStore_EvictsIdle(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newLimiterStore(10, time.Minute, 0)
+	store.nowFunc = func() time.Time { return now }
+
+	store.Get("192.0.2.1")
+	now = now.Add(30 * time.Second)
+	store.Get("192.0.2.2")
+	assert.Zero(t, store.Cleanup())
+
+	now = now.Add(45 * time.Second)
+	assert.Equal(t, 1, store.Cleanup(), "only the limiter idle for longer than the window is evicted")
+	assert.Equal(t, 1, store.Len())
+
+	// Using a limiter keeps it alive
+	store.Get("192.0.2.2")
+	now = now.Add(45 * time.Second)
+	assert.Zero(t, store.Cleanup())
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestLimiterStore_CapsEntries(t *testing.T) {
+	store := newLimiterStore(1, time.Hour, 100)
+	for i := 0; i < 5000; i++ {
+		store.Allow(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	assert.Equal(t, 100, store.Len())
+
+	// The least recently used limiter is evicted first
+	store.Get("10.0.19.36") // 4900, the oldest remaining
+	store.Get("192.0.2.1")
+	assert.Equal(t, 100, store.Len())
+	store.mu.Lock()
+	_, kept := store.entries["10.0.19.36"]
+	_, evicted := store.entries["10.0.19.37"]
+	store.mu.Unlock()
+	assert.True(t, kept)
+	assert.False(t, evicted)
+}
+
+func TestLimiterStore_Take(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newLimiterStore(3, time.Minute, 0)
+	store.nowFunc = func() time.Time { return now }
+
+	status := store.Take("192.0.2.1")
+	assert.Equal(t, RateLimitStatus{Allowed: true, Limit: 3, Remaining: 2, Reset: 20 * time.Second}, status)
+	store.Take("192.0.2.1")
+	status = store.Take("192.0.2.1")
+	assert.True(t, status.Allowed)
+	assert.Zero(t, status.Remaining)
+	assert.Equal(t, time.Minute, status.Reset)
+
+	status = store.Take("192.0.2.1")
+	assert.False(t, status.Allowed)
+	assert.Equal(t, 20*time.Second, status.RetryAfter)
+
+	now = now.Add(20 * time.Second)
+	assert.True(t, store.Take("192.0.2.1").Allowed, "denied requests do not use up the allowance")
+}
+
+func TestMemoryRateLimitBackend(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	backend := NewMemoryRateLimitBackend(0)
+	backend.nowFunc = func() time.Time { return now }
+	ctx := context.Background()
+
+	status, err := backend.Take(ctx, "192.0.2.1", 1, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	status, _ = backend.Take(ctx, "192.0.2.1", 1, time.Minute)
+	assert.False(t, status.Allowed)
+	status, _ = backend.Take(ctx, "192.0.2.1", 5, time.Minute)
+	assert.True(t, status.Allowed, "each limit has its own buckets")
+	assert.Equal(t, 2, backend.Len())
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, 2, backend.Cleanup())
+	assert.Zero(t, backend.Len())
+}
+
+func TestMemoryRateLimitBackend_RunStopsWithContext(t *testing.T) {
+	backend := NewMemoryRateLimitBackend(0)
+	backend.Take(context.Background(), "192.0.2.1", 1, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		backend.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return backend.Len() == 0 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := NewWithContext(ctx, &Config{RateLimitRequests: 100, RateLimitWindow: 60})
+
+	done := make(chan error)
+	go func() { done <- server.Close() }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close did not stop the background goroutines")
+	}
+}
+
+func TestParseRateLimitPolicy(t *testing.T) {
+	policy, err := ParseRateLimitPolicy("5/600")
+	require.NoError(t, err)
+	assert.Equal(t, RateLimitPolicy{Requests: 5, Window: 600}, policy)
+
+	policy, err = ParseRateLimitPolicy(" off ")
+	require.NoError(t, err)
+	assert.Zero(t, policy.Requests)
+
+	for _, value := range []string{"5", "five/60", "5/0", "-1/60", "5/1m"} {
+		_, err := ParseRateLimitPolicy(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRateLimits_ClientKey(t *testing.T) {
+	limits := newRateLimits(&Config{}, nil)
+	assert.Equal(t, "192.0.2.0/24", limits.clientKey("192.0.2.17"))
+	assert.Equal(t, "192.0.2.0/24", limits.clientKey("::ffff:192.0.2.200"))
+	assert.Equal(t, "2001:db8:1:2::/64", limits.clientKey("2001:db8:1:2:aaaa::1"))
+	assert.Equal(t, "not-an-ip", limits.clientKey("not-an-ip"))
+
+	limits = newRateLimits(&Config{RateLimitIPv4Prefix: 32, RateLimitIPv6Prefix: 128}, nil)
+	assert.Equal(t, "192.0.2.17/32", limits.clientKey("192.0.2.17"))
+	assert.Equal(t, "2001:db8::1/128", limits.clientKey("2001:db8::1"))
+}
+
+// rateLimitRequest sends a request from ip and returns the response
+func rateLimitRequest(server *Server, method, path, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":12345"
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRateLimitMiddleware_PerClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{RateLimitRequests: 2, RateLimitWindow: 60})
+	defer server.Close()
+
+	rr := rateLimitRequest(server, "POST", "/guestbook", "192.0.2.1")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "2;w=60", rr.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, rr.Header().Get("Retry-After"))
+
+	// Neighbouring addresses share the limit of their /24
+	assert.Equal(t, http.StatusBadRequest, rateLimitRequest(server, "POST", "/guestbook", "192.0.2.2").Code)
+	rr = rateLimitRequest(server, "POST", "/guestbook", "192.0.2.3")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusBadRequest, rateLimitRequest(server, "POST", "/guestbook", "198.51.100.1").Code)
+	assert.Equal(t, 2, server.rateLimits.backend.(*MemoryRateLimitBackend).Len())
+}
+
+func TestRateLimitMiddleware_RoutePolicies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{
+		RateLimitRequests: 100,
+		RateLimitWindow:   60,
+		RateLimitPolicies: map[string]RateLimitPolicy{"POST /guestbook": {Requests: 1, Window: 600}},
+		FormTokens:        true,
+	})
+	defer server.Close()
+
+	assert.Equal(t, http.StatusBadRequest, rateLimitRequest(server, "POST", "/guestbook", "192.0.2.1").Code)
+	rr := rateLimitRequest(server, "POST", "/guestbook", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "600", rr.Header().Get("Retry-After"))
+
+	// Other routes keep their own allowance
+	rr = rateLimitRequest(server, "GET", "/form-token", "192.0.2.1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "100;w=60", rr.Header().Get("RateLimit-Policy"))
+
+	for i := 0; i < 200; i++ {
+		rr = rateLimitRequest(server, "GET", "/health", "192.0.2.1")
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"), "health checks are not limited")
+
+	rr = rateLimitRequest(server, "OPTIONS", "/guestbook", "192.0.2.1")
+	assert.Equal(t, http.StatusOK, rr.Code, "preflights are not limited")
+}
+
+// submitEntry posts a valid guestbook entry from ip
+func submitEntry(server *Server, ip, message string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"name": "Visitor", "message": message, "g-recaptcha-response": "mock-response"})
+	req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":12345"
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRateLimitMiddleware_GlobalCeiling(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 10, RateLimitWindow: 60, RateLimitGlobalHourly: 2})
+	defer server.Close()
+	store := &MockEntryStore{}
+	records := &MockEntryStore{}
+	server.store = store
+	server.records = records
+	server.config.SpamAcceptThreshold = 0.3
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+
+	assert.Equal(t, http.StatusOK, submitEntry(server, "192.0.2.1", "Hello from Alice").Code)
+	assert.Equal(t, http.StatusOK, submitEntry(server, "198.51.100.1", "Hello from Bob").Code)
+	rr := submitEntry(server, "203.0.113.1", "Hello from Carol")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1800", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), "Too many submissions")
+	assert.Len(t, store.entries, 2)
+	require.Len(t, records.entries, 3, "turned away submissions are recorded too")
+	assert.Equal(t, StatusRejected, records.entries[2].Status)
+	assert.Equal(t, "global submission rate limit reached", records.entries[2].Reason)
+
+	// Only submissions count towards the ceiling
+	assert.Equal(t, http.StatusOK, rateLimitRequest(server, "GET", "/health", "203.0.113.1").Code)
+}
+
+func TestRateLimitMiddleware_GlobalCeilingIgnoresSpam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 100, RateLimitWindow: 60, RateLimitGlobalHourly: 2})
+	defer server.Close()
+	store := &MockEntryStore{}
+	server.store = store
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+
+	// A bot flooding rejected submissions does not lock out real visitors
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, http.StatusBadRequest, rateLimitRequest(server, "POST", "/guestbook", "192.0.2.1").Code)
+	}
+	assert.Equal(t, http.StatusOK, submitEntry(server, "198.51.100.1", "Hello from Bob").Code)
+	assert.Len(t, store.entries, 1)
+}
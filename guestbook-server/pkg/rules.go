@@ -0,0 +1,225 @@
+package guestbook_server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SpamRules are the content heuristics, loaded from the YAML or JSON file at
+// Config.SpamRulesPath. Omitted fields keep their built-in defaults; an empty
+// list disables the built-in keywords or patterns.
+type SpamRules struct {
+	Keywords    []string           `yaml:"keywords" json:"keywords"`
+	Patterns    []SpamRulePattern  `yaml:"patterns" json:"patterns"`
+	LinkPattern string             `yaml:"link_pattern" json:"link_pattern"`
+	MaxLinks    *int               `yaml:"max_links" json:"max_links"`   // More links than this adds the "links" weight; 0 allows none
+	MaxLength   int                `yaml:"max_length" json:"max_length"` // Longer messages add the "length" weight
+	Weights     map[string]float64 `yaml:"weights" json:"weights"`       // Overrides defaultSpamWeights
+	// Links to allowed domains and their subdomains pass the domains check,
+	// links to blocked ones are rejected, and links to any other domain are
+	// held for review
+	AllowedDomains []string `yaml:"allowed_domains" json:"allowed_domains"`
+	BlockedDomains []string `yaml:"blocked_domains" json:"blocked_domains"`
+	Shorteners     []string `yaml:"shorteners" json:"shorteners"` // URL shorteners, which add the "shortener" weight
+}
+
+// SpamRulePattern is a regular expression matched against the name and
+// message after normalizeText. Its name is the spam signal it raises.
+type SpamRulePattern struct {
+	Name   string  `yaml:"name" json:"name"`
+	Regex  string  `yaml:"regex" json:"regex"`
+	Weight float64 `yaml:"weight" json:"weight"` // Used unless the weights map sets one; defaults to 1
+}
+
+// defaultSpamRules are used until a rules file is loaded
+var defaultSpamRules = SpamRules{
+	Keywords: []string{
+		"click here", "buy now", "free", "offer", "deal",
+		"viagra", "casino", "loan", "crypto", "bitcoin",
+	},
+	Patterns: []SpamRulePattern{
+		{Name: "bbcode_link", Regex: `(?i)\[(url|link)=http`},
+	},
+	LinkPattern: `(http|ftp|https)://([\w_-]+(?:(?:\.[\w_-]+)+))([\w.,@?^=%&:/~+#-]*[\w@?^=%&/~+#-])?`,
+	MaxLinks:    intPtr(2),
+	MaxLength:   1000,
+	Shorteners:  defaultShorteners,
+}
+
+var defaultSpamRuleSet = mustCompileSpamRules(defaultSpamRules)
+
+// spamRuleSet is a compiled SpamRules
+type spamRuleSet struct {
+	keywords   []string // Normalized with normalizeText
+	matcher    *keywordMatcher
+	patterns   []spamRuleRegex
+	link       *regexp.Regexp
+	maxLinks   int
+	maxLength  int
+	weights    map[string]float64
+	allowed    domainSet
+	blocked    domainSet
+	shorteners domainSet
+	// modTime and size identify the version of the rules file that was loaded
+	modTime time.Time
+	size    int64
+}
+
+type spamRuleRegex struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+func intPtr(n int) *int { return &n }
+
+// compileSpamRules validates rules, filling in defaults for omitted fields
+func compileSpamRules(rules SpamRules) (*spamRuleSet, error) {
+	if rules.Keywords == nil {
+		rules.Keywords = defaultSpamRules.Keywords
+	}
+	if rules.Patterns == nil {
+		rules.Patterns = defaultSpamRules.Patterns
+	}
+	if rules.LinkPattern == "" {
+		rules.LinkPattern = defaultSpamRules.LinkPattern
+	}
+	if rules.MaxLinks == nil {
+		rules.MaxLinks = defaultSpamRules.MaxLinks
+	}
+	if *rules.MaxLinks < 0 {
+		return nil, fmt.Errorf("max_links must not be negative")
+	}
+	if rules.MaxLength <= 0 {
+		rules.MaxLength = defaultSpamRules.MaxLength
+	}
+	if rules.Shorteners == nil {
+		rules.Shorteners = defaultSpamRules.Shorteners
+	}
+
+	set := &spamRuleSet{
+		maxLinks:   *rules.MaxLinks,
+		maxLength:  rules.MaxLength,
+		weights:    make(map[string]float64),
+		allowed:    newDomainSet(rules.AllowedDomains),
+		blocked:    newDomainSet(rules.BlockedDomains),
+		shorteners: newDomainSet(rules.Shorteners),
+	}
+	for signal, weight := range rules.Weights {
+		set.weights[signal] = weight
+	}
+	set.matcher = newKeywordMatcher(rules.Keywords)
+	set.keywords = set.matcher.keywords
+	for _, pattern := range rules.Patterns {
+		if pattern.Name == "" {
+			return nil, fmt.Errorf("pattern %q has no name", pattern.Regex)
+		}
+		regex, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %s: %w", pattern.Name, err)
+		}
+		set.patterns = append(set.patterns, spamRuleRegex{name: pattern.Name, regex: regex})
+		if _, ok := set.weights[pattern.Name]; !ok && pattern.Weight != 0 {
+			set.weights[pattern.Name] = pattern.Weight
+		}
+	}
+
+	link, err := regexp.Compile(rules.LinkPattern)
+	if err != nil {
+		return nil, fmt.Errorf("link_pattern: %w", err)
+	}
+	set.link = link
+	return set, nil
+}
+
+func mustCompileSpamRules(rules SpamRules) *spamRuleSet {
+	set, err := compileSpamRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// loadSpamRules reads and compiles a rules file. JSON is valid YAML, so both
+// formats are parsed the same way.
+func loadSpamRules(path string) (*spamRuleSet, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spam rules: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spam rules: %w", err)
+	}
+	var rules SpamRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse spam rules %s: %w", path, err)
+	}
+	set, err := compileSpamRules(rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spam rules %s: %w", path, err)
+	}
+	set.modTime, set.size = info.ModTime(), info.Size()
+	return set, nil
+}
+
+// spamRules returns the active rules
+func (s *Server) spamRules() *spamRuleSet {
+	if rules := s.rules.Load(); rules != nil {
+		return rules
+	}
+	return defaultSpamRuleSet
+}
+
+// reloadSpamRules replaces the active rules with the rules file. The current
+// rules stay in place if the file cannot be loaded.
+func (s *Server) reloadSpamRules() error {
+	rules, err := loadSpamRules(s.config.SpamRulesPath)
+	if err != nil {
+		return err
+	}
+	s.rules.Store(rules)
+	log.Printf("Loaded spam rules from %s: %d keywords, %d patterns", s.config.SpamRulesPath, len(rules.keywords), len(rules.patterns))
+	return nil
+}
+
+// watchSpamRules reloads the rules file on SIGHUP and whenever its
+// modification time or size changes, until ctx is cancelled
+func (s *Server) watchSpamRules(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Start from the version that was loaded so changes made since are picked up
+	loaded := s.spamRules()
+	modTime, size := loaded.modTime, loaded.size
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			serverDebugLog("Received SIGHUP, reloading spam rules")
+		case <-ticker.C:
+			info, err := os.Stat(s.config.SpamRulesPath)
+			if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+				continue
+			}
+			modTime, size = info.ModTime(), info.Size()
+			serverDebugLog("Spam rules file changed, reloading")
+		}
+		if err := s.reloadSpamRules(); err != nil {
+			log.Printf("Failed to reload spam rules, keeping the current rules: %v", err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package guestbook_server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRulesFile(t *testing.T, path, contents string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+}
+
+func newRulesTestServer(t *testing.T, path string) *Server {
+	t.Helper()
+	return New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 100, RateLimitWindow: 60, SpamRulesPath: path})
+}
+
+func TestLoadSpamRules_YAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRulesFile(t, path, `
+keywords: [essay writing, Replica Watches]
+patterns:
+  - name: telegram
+    regex: 't\.me/'
+    weight: 0.7
+max_links: 5
+weights:
+  keyword: 0.5
+`)
+	rules, err := loadSpamRules(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"essay writing", "replica watches"}, rules.keywords)
+	require.Len(t, rules.patterns, 1)
+	assert.Equal(t, "telegram", rules.patterns[0].name)
+	assert.Equal(t, 5, rules.maxLinks)
+	assert.Equal(t, defaultSpamRules.MaxLength, rules.maxLength, "omitted limits keep their defaults")
+	assert.Equal(t, 0.7, rules.weights["telegram"])
+	assert.Equal(t, 0.5, rules.weights["keyword"])
+}
+
+func TestLoadSpamRules_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeRulesFile(t, path, `{"keywords": [], "max_length": 200}`)
+
+	rules, err := loadSpamRules(path)
+	require.NoError(t, err)
+	assert.Empty(t, rules.keywords, "an empty list disables the built-in keywords")
+	assert.Len(t, rules.patterns, len(defaultSpamRules.Patterns))
+	assert.Equal(t, 200, rules.maxLength)
+}
+
+func TestLoadSpamRules_NoLinksAllowed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRulesFile(t, path, "max_links: 0\n")
+
+	rules, err := loadSpamRules(path)
+	require.NoError(t, err)
+	assert.Zero(t, rules.maxLinks, "an explicit 0 is not replaced by the default")
+
+	server := newRulesTestServer(t, path)
+	signals := server.spamSignals(GuestbookRequest{Name: "Bob", Message: "See https://example.com"})
+	require.Len(t, signals, 1)
+	assert.Equal(t, "links", signals[0].Name)
+}
+
+func TestLoadSpamRules_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := loadSpamRules(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+
+	path := filepath.Join(dir, "rules.yaml")
+	writeRulesFile(t, path, "patterns:\n  - name: broken\n    regex: '[unclosed'\n")
+	_, err = loadSpamRules(path)
+	assert.ErrorContains(t, err, "broken")
+
+	writeRulesFile(t, path, "patterns:\n  - regex: 'x'\n")
+	_, err = loadSpamRules(path)
+	assert.ErrorContains(t, err, "no name")
+
+	writeRulesFile(t, path, "max_links: -1\n")
+	_, err = loadSpamRules(path)
+	assert.ErrorContains(t, err, "max_links")
+}
+
+func TestSpamRules_AppliedToSignals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRulesFile(t, path, "keywords: [replica watches]\npatterns:\n  - name: telegram\n    regex: 't\\.me/'\n")
+	server := newRulesTestServer(t, path)
+
+	signals := server.spamSignals(GuestbookRequest{Name: "Bob", Message: "Replica watches at t.me/bob"})
+	require.Len(t, signals, 2)
+	assert.Equal(t, "telegram", signals[0].Name)
+	assert.Equal(t, "keyword", signals[1].Name)
+	assert.Equal(t, 1.0, server.spamWeight("telegram"), "patterns without a weight count fully")
+
+	assert.Empty(t, server.spamSignals(GuestbookRequest{Name: "Bob", Message: "Free casino"}), "built-in keywords were replaced")
+}
+
+func TestSpamRules_ReloadKeepsRulesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRulesFile(t, path, "keywords: [first]\n")
+	server := newRulesTestServer(t, path)
+	require.Equal(t, []string{"first"}, server.spamRules().keywords)
+
+	writeRulesFile(t, path, "keywords: [second\n")
+	assert.Error(t, server.reloadSpamRules())
+	assert.Equal(t, []string{"first"}, server.spamRules().keywords)
+
+	writeRulesFile(t, path, "keywords: [second]\n")
+	require.NoError(t, server.reloadSpamRules())
+	assert.Equal(t, []string{"second"}, server.spamRules().keywords)
+}
+
+func TestSpamRules_WatchReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRulesFile(t, path, "keywords: [first]\n")
+	server := newRulesTestServer(t, path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.watchSpamRules(ctx, 10*time.Millisecond)
+
+	writeRulesFile(t, path, "keywords: [second, third]\n")
+	assert.Eventually(t, func() bool {
+		return len(server.spamRules().keywords) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestSpamRules_MissingFileUsesDefaults(t *testing.T) {
+	server := newRulesTestServer(t, filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Equal(t, defaultSpamRuleSet, server.spamRules())
+}
+
+func TestLoadSpamRules_ExampleFile(t *testing.T) {
+	rules, err := loadSpamRules("../spam-rules.example.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, defaultSpamRuleSet.keywords, rules.keywords)
+	assert.Len(t, rules.patterns, 2)
+}
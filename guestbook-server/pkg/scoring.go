@@ -0,0 +1,106 @@
+package guestbook_server
+
+import (
+	"fmt"
+)
+
+// defaultSpamWeights is how much each spam signal adds to a submission's spam
+// score. A single strong signal reaches defaultSpamRejectThreshold on its own,
+// while weak signals such as a keyword only do in combination.
+var defaultSpamWeights = map[string]float64{
+	"honeypot":    1.0,
+	"formtoken":   1.0, // Missing or forged form token, or a form submitted too fast
+	"recaptcha":   1.0, // Scaled by 1 - score for reCAPTCHA v3 responses that pass
+	"akismet":     1.0,
+	"bbcode_link": 1.0,
+	"keyword":     0.4, // Per distinct keyword
+	"links":       0.5,
+	"length":      0.5,
+	"repetitive":  0.6,
+	"bayes":       1.0, // Scaled by how far the spam probability is above 0.5
+	"duplicate":   1.0, // Near-duplicate of a recent submission from another IP
+	"shortener":   0.5, // Link through a URL shortener
+	"punycode":    0.5, // Link to an internationalized domain
+}
+
+// defaultSpamRejectThreshold is the spam score at which submissions are rejected
+const defaultSpamRejectThreshold = 1.0
+
+// spamSignal is one piece of evidence that a submission is spam
+type spamSignal struct {
+	Name   string // Key into the spam weights
+	Detail string
+}
+
+// spamWeight returns the configured weight of a signal
+func (s *Server) spamWeight(signal string) float64 {
+	if s.config != nil {
+		if weight, ok := s.config.SpamWeights[signal]; ok {
+			return weight
+		}
+	}
+	if weight, ok := s.spamRules().weights[signal]; ok {
+		return weight
+	}
+	if weight, ok := defaultSpamWeights[signal]; ok {
+		return weight
+	}
+	// Signals raised by rules file patterns without a weight
+	return 1.0
+}
+
+// spamRejectThreshold returns the score at which submissions are rejected
+func (s *Server) spamRejectThreshold() float64 {
+	if s.config != nil && s.config.SpamRejectThreshold > 0 {
+		return s.config.SpamRejectThreshold
+	}
+	return defaultSpamRejectThreshold
+}
+
+// spamAcceptThreshold returns the score below which submissions are published
+// without review. Zero disables auto-accept.
+func (s *Server) spamAcceptThreshold() float64 {
+	if s.config == nil {
+		return 0
+	}
+	return s.config.SpamAcceptThreshold
+}
+
+// spamSignals returns every content heuristic in the active spam rules that
+// fires for req
+func (s *Server) spamSignals(req GuestbookRequest) []spamSignal {
+	var signals []spamSignal
+	rules := s.spamRules()
+
+	content := normalizeText(req.Name + " " + req.Message)
+	for _, pattern := range rules.patterns {
+		if pattern.regex.MatchString(content) {
+			serverDebugLog("Spam pattern %s matched content from %s", pattern.name, req.Name)
+			signals = append(signals, spamSignal{pattern.name, fmt.Sprintf("pattern %s", pattern.name)})
+		}
+	}
+	for _, keyword := range rules.matcher.Match(req.Name + " " + req.Message) {
+		serverDebugLog("Suspicious keyword detected: '%s' in content from %s", keyword, req.Name)
+		signals = append(signals, spamSignal{"keyword", fmt.Sprintf("keyword %q", keyword)})
+	}
+
+	// Check for excessive links
+	if links := len(rules.link.FindAllString(req.Message, -1)); links > rules.maxLinks {
+		serverDebugLog("Too many links detected in message from %s", req.Name)
+		signals = append(signals, spamSignal{"links", fmt.Sprintf("%d links", links)})
+	}
+
+	// Check for excessive length
+	if len(req.Message) > rules.maxLength {
+		serverDebugLog("Message too long (%d chars) from %s", len(req.Message), req.Name)
+		signals = append(signals, spamSignal{"length", fmt.Sprintf("message too long (%d chars)", len(req.Message))})
+	}
+
+	// Check for repetitive content
+	if isRepetitive(req.Message) {
+		serverDebugLog("Repetitive content detected from %s", req.Name)
+		signals = append(signals, spamSignal{"repetitive", "repetitive content"})
+	}
+
+	return signals
+}
@@ -2,18 +2,21 @@ package guestbook_server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"html"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/time/rate"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // Debug logging function for server
@@ -23,90 +26,241 @@ func serverDebugLog(format string, args ...interface{}) {
 	}
 }
 
-// GitHubClientInterface defines the interface for GitHub operations
-type GitHubClientInterface interface {
-	CreateGuestbookEntry(ctx context.Context, req GuestbookRequest) error
-}
-
 type Config struct {
 	Port                    string
 	AkismetAPIKey           string
 	AkismetSiteURL          string
+	AkismetBlogLang         string // Language codes sent to Akismet as blog_lang, e.g. "en"
+	AkismetTestMode         bool   // Send is_test so Akismet does not learn from our requests
+	AkismetRequireValidKey  bool   // Refuse to start when the Akismet key cannot be verified
+	AkismetEndpoint         string // Overrides the Akismet REST API base URL, e.g. for a local stand-in
 	RecaptchaSecretKey      string
 	RecaptchaScoreThreshold float64
+	CaptchaProvider         string                   // "recaptcha" (default), "hcaptcha", "turnstile", or "pow" for the self-hosted proof-of-work challenge
+	CaptchaSecretKey        string                   // Secret for the captcha provider, RecaptchaSecretKey when empty
+	CaptchaVerifyURL        string                   // Overrides the provider's siteverify endpoint, e.g. for a local stand-in
+	CaptchaField            string                   // Overrides the form field the provider's token is read from
+	CaptchaHostnames        []string                 // Hostnames tokens must be minted for, the AllowedOrigins hosts when empty
+	CaptchaMaxTokenAge      int                      // Seconds after solving that a token is accepted, default 120
+	RecaptchaVersion        string                   // "v3" (default) or "v2" for the checkbox
+	RecaptchaActions        []string                 // Accepted reCAPTCHA v3 actions, default "submit"
+	ProofOfWorkSecret       string                   // Key signing proof-of-work challenges, random per process when empty
+	ProofOfWorkDifficulty   int                      // Leading zero bits required of proof-of-work solutions, default 16
+	FormTokens              bool                     // Serve GET /form-token and require its signed, timestamped token with submissions
+	FormTokenSecret         string                   // Key signing form tokens, random per process when empty
+	FormTokenMinAge         int                      // Seconds after loading the form before it can be submitted, default 3
+	FormTokenMaxAge         int                      // Seconds a form token stays valid, default 7200
+	FailurePolicies         map[string]FailurePolicy // Per-check policy when a check errors, see defaultFailurePolicies
+	SpamChecks              []string                 // Spam checks to run, in order; defaultSpamChecks when empty
+	ShadowSpamChecks        []string                 // Spam checks whose results are recorded but never reject
+	SpamWeights             map[string]float64       // Per-signal spam score weights, see defaultSpamWeights
+	SpamAcceptThreshold     float64                  // Scores below this are published without review; 0 disables
+	SpamRejectThreshold     float64                  // Scores at or above this are rejected; defaultSpamRejectThreshold when 0
+	SpamRulesPath           string                   // YAML or JSON spam rules file, built-in rules when empty
+	SpamRulesReloadInterval int                      // Seconds between checks of the rules file for changes, default 30
+	BayesModelPath          string                   // Bayes classifier model written by the train subcommand, disabled when empty
+	DuplicateWindow         int                      // Seconds accepted submissions are remembered for duplicate detection, default 3600
+	DomainBlocklistPath     string                   // File of blocked link domains, one per line or in hosts-file format
+	IPAllowlist             []string                 // Addresses and CIDR ranges that skip the akismet, heuristics and bayes checks
+	IPAllowlistPath         string                   // File of further allowlisted ranges, one per line
+	IPBlocklist             []string                 // Addresses and CIDR ranges whose submissions are rejected as spam
+	IPBlocklistPath         string                   // File of further blocked ranges, such as hosting providers
 	GitHubToken             string
 	GitHubOwner             string
 	GitHubRepo              string
 	GitHubBranch            string
+	GitHubPublishMode       string // "pull-request" (default), "direct" or "pending-branch" for approved entries
+	GitHubPendingBranch     string // Branch collecting approved entries in pending-branch mode, default guestbook-pending
+	StorageBackend          string // "github" (default) or "file"
+	FileStorePath           string // Hugo site checkout used by the file backend
+	DatabasePath            string // SQLite database recording every submission, disabled when empty
+	AdminToken              string // Bearer token for the /admin API, disabled when empty
 	AllowedOrigins          []string
 	AllowedRedirectDomains  []string
 	RedirectURL             string
-	RateLimitRequests       int
-	RateLimitWindow         int
-}
-
-type RecaptchaVerifier interface {
-	Verify(ctx context.Context, response, remoteIP string) (bool, error)
+	RateLimitRequests       int                        // Requests per window allowed to each client on routes without a policy of their own
+	RateLimitWindow         int                        // Seconds
+	RateLimitPolicies       map[string]RateLimitPolicy // Per-route policies by "METHOD /path", see defaultRateLimitPolicies
+	RateLimitIPv4Prefix     int                        // IPv4 clients in the same block share a limit, default 24
+	RateLimitIPv6Prefix     int                        // IPv6 clients in the same block share a limit, default 64
+	RateLimitGlobalHourly   int                        // Submissions per hour accepted from all clients together, unlimited when 0
+	RateLimitMaxClients     int                        // Most clients whose rate limits are tracked at once per route, default 100000
+	RateLimitBackend        string                     // "memory" (default) or "redis" to share limits between replicas
+	RedisURL                string                     // e.g. redis://localhost:6379/0, for the redis rate limit backend
+	TrustedProxies          []string                   // Addresses and CIDR ranges of proxies whose forwarded client IP headers are believed
+	RemoteIPHeaders         []string                   // Headers read from trusted proxies, X-Forwarded-For and X-Real-IP when empty
+	TrustedPlatform         string                     // "cloudflare", "google-app-engine", "flyio" or a header always holding the client IP
 }
 
 type Server struct {
-	config         *Config
-	router         *gin.Engine
-	ipRateLimiters map[string]*rate.Limiter
-	mu             *sync.Mutex
-	akismet        *AkismetClient
-	recaptcha      RecaptchaVerifier
-	github         GitHubClientInterface
+	config  *Config
+	router  *gin.Engine
+	akismet *AkismetClient
+	captcha CaptchaVerifier
+	store   EntryStore
+	// rateLimits holds the rate limiters of each route
+	rateLimits *rateLimits
+	// records keeps every submission, accepted or not, for auditing. It is nil
+	// unless DatabasePath is configured.
+	records ModerationStore
+	// tasks tracks background work such as Akismet feedback reports
+	tasks sync.WaitGroup
+	// stop cancels the context of the goroutines tracked by background,
+	// which run until the server is closed
+	stop       context.CancelFunc
+	background sync.WaitGroup
+	// rules holds the spam rules loaded from SpamRulesPath, nil until loaded
+	rules atomic.Pointer[spamRuleSet]
+	// bayes is the spam classifier loaded from BayesModelPath, nil when disabled
+	bayes *BayesModel
+	// duplicates remembers recently accepted submissions
+	duplicates *duplicateTracker
+	// domainBlocklist is loaded from DomainBlocklistPath
+	domainBlocklist domainSet
+	// ipAllowlist and ipBlocklist combine the configured ranges with their files
+	ipAllowlist ipList
+	ipBlocklist ipList
+	// pow issues and verifies proof-of-work challenges when it is the
+	// captcha provider, nil otherwise
+	pow *ProofOfWork
+	// formTokens issues and checks form tokens when Config.FormTokens is set
+	formTokens *FormTokens
 }
 
 func New(config *Config) *Server {
+	return NewWithContext(context.Background(), config)
+}
+
+// NewWithContext creates a server whose background goroutines, such as rate
+// limiter cleanup and spam rules reloading, stop when ctx is cancelled or
+// the server is closed
+func NewWithContext(ctx context.Context, config *Config) *Server {
+	ctx, stop := context.WithCancel(ctx)
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery())
+	if err := configureClientIP(router, config); err != nil {
+		log.Printf("Failed to configure trusted proxies, using connection addresses: %v", err)
+	}
+	router.Use(gin.Logger(), gin.Recovery(), clientIPLogger(config))
 
 	// Initialize clients
-	akismet := NewAkismetClient(config.AkismetAPIKey, config.AkismetSiteURL)
-	recaptcha := NewRecaptchaClient(config.RecaptchaSecretKey, config.RecaptchaScoreThreshold)
-	github := NewGitHubClient(config.GitHubToken, config.GitHubOwner, config.GitHubRepo, config.GitHubBranch)
+	akismet := NewAkismetClient(config.AkismetAPIKey, config.AkismetSiteURL, WithBaseURL(config.AkismetEndpoint))
+	captcha, pow := newCaptchaVerifier(config)
+	store, err := newEntryStore(config)
+	if err != nil {
+		log.Printf("Failed to configure storage backend: %v", err)
+	}
+
+	backend, err := newRateLimitBackend(config)
+	if err != nil {
+		log.Printf("Failed to configure rate limit backend, keeping limits in memory: %v", err)
+		backend = NewMemoryRateLimitBackend(config.RateLimitMaxClients)
+	}
 
 	server := &Server{
-		config:         config,
-		router:         router,
-		ipRateLimiters: make(map[string]*rate.Limiter),
-		mu:             &sync.Mutex{},
-		akismet:        akismet,
-		recaptcha:      recaptcha,
-		github:         github,
+		config:     config,
+		router:     router,
+		akismet:    akismet,
+		captcha:    captcha,
+		store:      store,
+		rateLimits: newRateLimits(config, backend),
+		stop:       stop,
+		pow:        pow,
+		duplicates: newDuplicateTracker(time.Duration(config.DuplicateWindow) * time.Second),
+	}
+
+	if config.FormTokens {
+		server.formTokens = NewFormTokens(config.FormTokenSecret,
+			time.Duration(config.FormTokenMinAge)*time.Second, time.Duration(config.FormTokenMaxAge)*time.Second)
+	}
+
+	if config.DatabasePath != "" {
+		records, err := NewSQLiteStore(config.DatabasePath)
+		if err != nil {
+			log.Printf("Failed to open submission database: %v", err)
+		} else {
+			server.records = records
+		}
+	}
+
+	if config.SpamRulesPath != "" {
+		if err := server.reloadSpamRules(); err != nil {
+			log.Printf("Failed to load spam rules, using built-in rules: %v", err)
+		}
+		interval := time.Duration(config.SpamRulesReloadInterval) * time.Second
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		server.goBackground(func() { server.watchSpamRules(ctx, interval) })
+	}
+
+	if config.BayesModelPath != "" {
+		model, err := LoadBayesModel(config.BayesModelPath)
+		if err != nil {
+			log.Printf("Failed to load Bayes model, classifier disabled: %v", err)
+		} else {
+			log.Printf("Loaded Bayes model from %s (%d spam, %d ham documents)", config.BayesModelPath, model.SpamDocs, model.HamDocs)
+			server.bayes = model
+		}
+	}
+
+	if config.DomainBlocklistPath != "" {
+		blocklist, err := loadDomainList(config.DomainBlocklistPath)
+		if err != nil {
+			log.Printf("Failed to load domain blocklist: %v", err)
+		} else {
+			log.Printf("Loaded %d blocked domains from %s", len(blocklist), config.DomainBlocklistPath)
+			server.domainBlocklist = blocklist
+		}
+	}
+
+	if allowed, err := loadIPLists(config.IPAllowlist, config.IPAllowlistPath); err != nil {
+		log.Printf("Failed to load IP allowlist: %v", err)
+	} else {
+		server.ipAllowlist = allowed
+	}
+	if blocked, err := loadIPLists(config.IPBlocklist, config.IPBlocklistPath); err != nil {
+		log.Printf("Failed to load IP blocklist: %v", err)
+	} else {
+		server.ipBlocklist = blocked
+	}
+	if len(server.ipAllowlist) > 0 || len(server.ipBlocklist) > 0 {
+		log.Printf("Loaded %d allowlisted and %d blocked IP ranges", len(server.ipAllowlist), len(server.ipBlocklist))
 	}
 
 	server.setupRoutes()
 
-	// Start a background goroutine to clean up old rate limiters
-	go server.cleanupRateLimiters()
+	// Evict idle rate limiters in the background
+	if memory, ok := backend.(*MemoryRateLimitBackend); ok {
+		server.goBackground(func() { memory.Run(ctx, time.Minute) })
+	}
 
 	return server
 }
 
-func (s *Server) cleanupRateLimiters() {
-	for {
-		// Wait for a specified interval before cleaning up
-		time.Sleep(10 * time.Minute)
-
-		s.mu.Lock()
-		// Create a new map for active limiters
-		cleanedLimiters := make(map[string]*rate.Limiter)
-		for ip, limiter := range s.ipRateLimiters {
-			// A simple heuristic: if the limiter has not been used recently,
-			// it might be considered for removal. Here we check if the limit
-			// has been reached, which is a proxy for recent activity.
-			// A more robust solution would track last access time.
-			if limiter.Burst() < s.config.RateLimitRequests {
-				cleanedLimiters[ip] = limiter
-			}
+// goBackground runs f in a goroutine that Close waits for
+func (s *Server) goBackground(f func()) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		f()
+	}()
+}
+
+// Close stops the server's background goroutines, waits for pending tasks
+// such as Akismet feedback reports, and closes the submission database and
+// rate limit backend
+func (s *Server) Close() error {
+	s.stop()
+	s.background.Wait()
+	s.tasks.Wait()
+	var errs []error
+	for _, resource := range []any{s.records, s.rateLimits.backend} {
+		if closer, ok := resource.(io.Closer); ok {
+			errs = append(errs, closer.Close())
 		}
-		s.ipRateLimiters = cleanedLimiters
-		s.mu.Unlock()
 	}
+	return errors.Join(errs...)
 }
 
 func (s *Server) setupRoutes() {
@@ -119,7 +273,7 @@ func (s *Server) setupRoutes() {
 				break
 			}
 		}
-		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type")
 
 		if c.Request.Method == "OPTIONS" {
@@ -140,40 +294,73 @@ func (s *Server) setupRoutes() {
 
 	// Guestbook submission endpoint
 	s.router.POST("/guestbook", s.handleGuestbookSubmission)
-}
 
-func (s *Server) rateLimitMiddleware(c *gin.Context) {
-	ip := c.ClientIP()
-	limiter := s.getRateLimiter(ip)
+	// Proof-of-work challenges for the form to solve
+	if s.pow != nil {
+		s.router.GET("/challenge", s.handleChallenge)
+	}
 
-	if !limiter.Allow() {
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
-		c.Abort()
-		return
+	// Form tokens timing how long visitors take to fill in the form
+	if s.formTokens != nil {
+		s.router.GET("/form-token", s.handleFormToken)
 	}
 
-	c.Next()
+	s.setupAdminRoutes()
+}
+
+func (s *Server) Start() error {
+	if err := s.verifyAkismetKey(context.Background()); err != nil {
+		return err
+	}
+	return s.router.Run(":" + s.config.Port)
 }
 
-func (s *Server) getRateLimiter(ip string) *rate.Limiter {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// verifyAkismetKey checks the Akismet key once at startup, since an invalid key
+// otherwise only shows up as an error on every submission. It returns an error
+// only when AkismetRequireValidKey is set.
+func (s *Server) verifyAkismetKey(ctx context.Context) error {
+	if s.akismet == nil {
+		return nil
+	}
 
-	limiter, exists := s.ipRateLimiters[ip]
-	if !exists {
-		// Create a new limiter for this IP
-		limiter = rate.NewLimiter(
-			rate.Every(time.Duration(s.config.RateLimitWindow)*time.Second/time.Duration(s.config.RateLimitRequests)),
-			s.config.RateLimitRequests,
-		)
-		s.ipRateLimiters[ip] = limiter
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	valid, err := s.akismet.VerifyKey(ctx)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("could not verify Akismet API key: %w", err)
+	case !valid:
+		err = fmt.Errorf("Akismet API key is invalid for %s", s.config.AkismetSiteURL)
+	default:
+		log.Printf("Akismet API key verified for %s", s.config.AkismetSiteURL)
+		return nil
 	}
 
-	return limiter
+	if s.config.AkismetRequireValidKey {
+		return err
+	}
+	log.Printf("WARNING: %v; Akismet spam checks will fail until this is fixed", err)
+	return nil
 }
 
-func (s *Server) Start() error {
-	return s.router.Run(":" + s.config.Port)
+// akismetComment describes an entry the way Akismet expects it. Entries are
+// stored HTML-escaped, so the author and content are unescaped again.
+func (s *Server) akismetComment(entry *GuestbookEntry) AkismetComment {
+	return AkismetComment{
+		UserIP:            entry.UserIP,
+		UserAgent:         entry.UserAgent,
+		Referrer:          entry.Referrer,
+		Permalink:         entry.Referrer,
+		CommentType:       "guestbook",
+		CommentAuthor:     html.UnescapeString(entry.Name),
+		CommentContent:    html.UnescapeString(entry.Message),
+		CommentDate:       time.Unix(entry.Date, 0),
+		BlogLang:          s.config.AkismetBlogLang,
+		BlogCharset:       "UTF-8",
+		IsTest:            s.config.AkismetTestMode,
+		HoneypotFieldName: "website",
+	}
 }
 
 func (s *Server) handleGuestbookSubmission(c *gin.Context) {
@@ -185,7 +372,7 @@ func (s *Server) handleGuestbookSubmission(c *gin.Context) {
 	contentType := c.GetHeader("Content-Type")
 	serverDebugLog("Request content type: %s", contentType)
 	if strings.Contains(contentType, "application/json") {
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 			serverDebugLog("Failed to bind JSON: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
 			return
@@ -198,8 +385,14 @@ func (s *Server) handleGuestbookSubmission(c *gin.Context) {
 		}
 	}
 
-	serverDebugLog("Parsed request - Name: %s, Message length: %d, RecaptchaResponse present: %t",
-		req.Name, len(req.Message), req.RecaptchaResponse != "")
+	// The token is bound from g-recaptcha-response; other providers use
+	// their own field
+	if field := s.captchaField(); field != "g-recaptcha-response" {
+		req.CaptchaResponse = captchaToken(c, field)
+	}
+
+	serverDebugLog("Parsed request - Name: %s, Message length: %d, captcha response present: %t",
+		req.Name, len(req.Message), req.CaptchaResponse != "")
 
 	// Validate required fields
 	if req.Name == "" {
@@ -207,94 +400,89 @@ func (s *Server) handleGuestbookSubmission(c *gin.Context) {
 		return
 	}
 
-	// Check honeypot field (if present, it's likely a bot)
-	if req.Honeypot != "" {
-		serverDebugLog("Honeypot field detected from IP: %s, silently rejecting", c.ClientIP())
-		c.JSON(http.StatusOK, gin.H{"message": "Thank you for your submission"})
-		return
-	}
-
-	// Verify reCAPTCHA (required)
-	if req.RecaptchaResponse == "" {
-		serverDebugLog("No reCAPTCHA response provided from IP: %s", c.ClientIP())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "reCAPTCHA verification is required"})
-		return
-	}
-
-	serverDebugLog("Verifying reCAPTCHA for IP: %s, Response length: %d", c.ClientIP(), len(req.RecaptchaResponse))
-	if s.recaptcha == nil {
-		serverDebugLog("reCAPTCHA client is nil, rejecting submission")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "reCAPTCHA client is nil"})
-		return
-	}
-
-	valid, err := s.recaptcha.Verify(c.Request.Context(), req.RecaptchaResponse, c.ClientIP())
-	if err != nil {
-		serverDebugLog("reCAPTCHA verification error: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "reCAPTCHA verification failed", "details": err.Error()})
+	entry := req.ToEntry()
+	entry.UserIP = c.ClientIP()
+	entry.UserAgent = c.Request.UserAgent()
+	entry.Referrer = c.Request.Referer()
+
+	verdict := s.spamPipeline().Run(c.Request.Context(), &Submission{Request: req, Entry: entry})
+	// The duplicate check reserves the submission's fingerprint so that
+	// concurrent copies are caught; release it unless the entry is stored
+	stored := false
+	defer func() {
+		if !stored {
+			s.duplicates.Forget(entry.ID)
+		}
+	}()
+	if verdict.Rejected() {
+		rejection := verdict.Rejection
+		serverDebugLog("Submission from %s (IP: %s) rejected by %s: %s", req.Name, c.ClientIP(), verdict.DecidedBy, rejection.Reason)
+		s.recordEntry(c, entry, rejection.Status, rejection.Reason)
+		if rejection.Error == "" {
+			c.JSON(rejection.Code, gin.H{"message": "Thank you for your submission"})
+			return
+		}
+		response := gin.H{"error": rejection.Error}
+		if rejection.Details != "" {
+			response["details"] = rejection.Details
+		}
+		c.JSON(rejection.Code, response)
 		// Log the error for debugging
 		c.Errors = append(c.Errors, &gin.Error{
-			Err:  fmt.Errorf("reCAPTCHA verification failed: %v", err),
+			Err:  fmt.Errorf("%s: %s", rejection.Error, rejection.Reason),
 			Type: gin.ErrorTypePublic,
 		})
 		return
 	}
-	if !valid {
-		serverDebugLog("reCAPTCHA verification failed: response was valid but score/success check failed for IP: %s", c.ClientIP())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "reCAPTCHA verification failed", "details": "Invalid reCAPTCHA response"})
-		// Log the error for debugging
-		c.Errors = append(c.Errors, &gin.Error{
-			Err:  fmt.Errorf("reCAPTCHA verification failed: invalid response"),
-			Type: gin.ErrorTypePublic,
-		})
+
+	if !s.allowGlobalSubmission(c) {
+		s.recordEntry(c, entry, StatusRejected, "global submission rate limit reached")
 		return
 	}
-	serverDebugLog("reCAPTCHA verification successful for IP: %s", c.ClientIP())
-
-	// Check for spam using Akismet
-	if s.akismet != nil {
-		serverDebugLog("Starting Akismet spam check for submission from %s", req.Name)
-		isSpam, err := s.akismet.CheckSpam(c.Request.Context(), AkismetComment{
-			UserIP:         c.ClientIP(),
-			UserAgent:      c.Request.UserAgent(),
-			Referrer:       c.Request.Referer(),
-			CommentType:    "guestbook",
-			CommentAuthor:  req.Name,
-			CommentContent: req.Message,
-		})
-		if err != nil {
-			serverDebugLog("Akismet check failed with error: %v", err)
-			fmt.Printf("Akismet error: %v\n", err)
-		} else if isSpam {
-			serverDebugLog("Akismet detected spam from %s (IP: %s), silently rejecting", req.Name, c.ClientIP())
-			c.JSON(http.StatusOK, gin.H{"message": "Thank you for your submission"})
-			return
-		} else {
-			serverDebugLog("Akismet check passed for %s (IP: %s)", req.Name, c.ClientIP())
-		}
-	} else {
-		serverDebugLog("Akismet client not configured, skipping spam check")
-	}
 
-	// Additional AI-based spam detection
-	serverDebugLog("Running additional spam heuristics for %s", req.Name)
-	if s.isLikelySpam(req) {
-		serverDebugLog("Custom spam heuristics detected spam from %s (IP: %s), silently rejecting", req.Name, c.ClientIP())
-		c.JSON(http.StatusOK, gin.H{"message": "Thank you for your submission"})
+	serverDebugLog("All spam checks passed with score %.2f, storing guestbook entry for %s", verdict.Score, req.Name)
+	status, reason := StatusPending, verdict.Reason()
+	if verdict.AutoAccepted {
+		status = StatusApproved
+	}
+	// Pending entries wait in the moderation queue and are published once
+	// approved; auto-accepted ones are published straight away
+	publish := !s.moderationEnabled() || status == StatusApproved
+	if publish && s.store == nil {
+		serverDebugLog("No storage backend configured, rejecting submission from %s", req.Name)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit entry"})
 		return
-	} else {
-		serverDebugLog("Custom spam heuristics passed for %s", req.Name)
 	}
-
-	// Create pull request with the guestbook entry
-	serverDebugLog("All spam checks passed, creating GitHub pull request for %s", req.Name)
-	if err := s.github.CreateGuestbookEntry(c.Request.Context(), req); err != nil {
-		serverDebugLog("Failed to create GitHub pull request for %s: %v", req.Name, err)
+	if err := s.recordEntry(c, entry, status, reason); err != nil && !publish {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit entry"})
 		return
 	}
+	if publish {
+		if err := s.store.CreateEntry(c.Request.Context(), entry); err != nil {
+			serverDebugLog("Failed to store guestbook entry for %s: %v", req.Name, err)
+			// The visitor is told to try again, so the record must not
+			// claim the entry was accepted
+			if s.records != nil {
+				entry.Status, entry.Reason = StatusRejected, fmt.Sprintf("failed to publish: %v", err)
+				if err := s.records.SetEntryStatus(c.Request.Context(), entry.ID, entry.Status, entry.Reason); err != nil {
+					log.Printf("Failed to record unpublished guestbook entry from %s: %v", entry.Name, err)
+				}
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit entry"})
+			return
+		}
+		// Stores publishing through pull requests leave the entry pending
+		if entry.Status != status && s.records != nil {
+			entry.Reason = reason + awaitingMerge
+			if err := s.records.SetEntryStatus(c.Request.Context(), entry.ID, entry.Status, entry.Reason); err != nil {
+				log.Printf("Failed to record %s guestbook entry from %s: %v", entry.Status, entry.Name, err)
+			}
+		}
+	}
 
-	serverDebugLog("Successfully created GitHub pull request for guestbook entry from %s", req.Name)
+	stored = true
+	s.duplicates.Remember(req, entry.UserIP, entry.ID)
+	serverDebugLog("Successfully stored guestbook entry from %s", req.Name)
 
 	// Redirect or return success
 	if req.Redirect != "" {
@@ -304,11 +492,35 @@ func (s *Server) handleGuestbookSubmission(c *gin.Context) {
 		} else {
 			serverDebugLog("Invalid redirect URL blocked: %s", req.Redirect)
 			// Do not redirect, instead return a generic success message
-			c.JSON(http.StatusOK, gin.H{"message": "Thank you for your submission! It will be reviewed before being published."})
+			c.JSON(http.StatusOK, gin.H{"message": submissionMessage(entry)})
 		}
 	} else {
-		c.JSON(http.StatusOK, gin.H{"message": "Thank you for your submission! It will be reviewed before being published."})
+		c.JSON(http.StatusOK, gin.H{"message": submissionMessage(entry)})
+	}
+}
+
+// submissionMessage tells the visitor whether their entry was published or
+// is waiting for review
+func submissionMessage(entry *GuestbookEntry) string {
+	if entry.Status == StatusApproved {
+		return "Thank you for your submission! It has been published and will appear shortly."
+	}
+	return "Thank you for your submission! It will be reviewed before being published."
+}
+
+// recordEntry saves the submission with its outcome to the submission database,
+// so silently rejected entries can still be audited
+func (s *Server) recordEntry(c *gin.Context, entry *GuestbookEntry, status EntryStatus, reason string) error {
+	entry.Status = status
+	entry.Reason = reason
+	if s.records == nil {
+		return nil
 	}
+	if err := s.records.CreateEntry(c.Request.Context(), entry); err != nil {
+		log.Printf("Failed to record %s guestbook entry from %s: %v", status, entry.Name, err)
+		return err
+	}
+	return nil
 }
 
 func (s *Server) isValidRedirect(redirectURL string) bool {
@@ -328,42 +540,10 @@ func (s *Server) isValidRedirect(redirectURL string) bool {
 	return false
 }
 
+// isLikelySpam reports whether any content heuristic fires for req. Whether
+// the submission is rejected depends on the weighted spam score.
 func (s *Server) isLikelySpam(req GuestbookRequest) bool {
-	// Simple heuristics for detecting spam
-	suspiciousPatterns := []string{
-		`[URL=http`, `[url=http`, `[link=http`,
-		"click here", "buy now", "free", "offer", "deal",
-		"viagra", "casino", "loan", "crypto", "bitcoin",
-	}
-
-	content := req.Name + " " + req.Message
-	for _, pattern := range suspiciousPatterns {
-		if strings.Contains(strings.ToLower(content), pattern) {
-			serverDebugLog("Suspicious pattern detected: '%s' in content from %s", pattern, req.Name)
-			return true
-		}
-	}
-
-	// Check for excessive links
-	linkRegex := regexp.MustCompile(`(http|ftp|https)://([\w_-]+(?:(?:\.[\w_-]+)+))([\w.,@?^=%&:/~+#-]*[\w@?^=%&/~+#-])?`)
-	if len(linkRegex.FindAllString(req.Message, -1)) > 2 {
-		serverDebugLog("Too many links detected in message from %s, flagging as spam", req.Name)
-		return true
-	}
-
-	// Check for excessive length
-	if len(req.Message) > 1000 {
-		serverDebugLog("Message too long (%d chars) from %s, flagging as spam", len(req.Message), req.Name)
-		return true
-	}
-
-	// Check for repetitive content
-	if isRepetitive(req.Message) {
-		serverDebugLog("Repetitive content detected from %s, flagging as spam", req.Name)
-		return true
-	}
-
-	return false
+	return len(s.spamSignals(req)) > 0
 }
 
 func isRepetitive(text string) bool {
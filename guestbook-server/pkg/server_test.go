@@ -35,7 +35,8 @@ func TestNew(t *testing.T) {
 	assert.NotNil(t, server)
 	assert.Equal(t, config, server.config)
 	assert.NotNil(t, server.router)
-	assert.NotNil(t, server.ipRateLimiters)
+	assert.NotNil(t, server.rateLimits)
+	assert.NoError(t, server.Close())
 }
 
 func TestHealthEndpoint(t *testing.T) {
@@ -211,44 +212,125 @@ func TestRateLimiting(t *testing.T) {
 
 	// Make requests up to the limit
 	for i := 0; i < 2; i++ {
-		req, err := http.NewRequest("GET", "/health", nil)
+		req, err := http.NewRequest("POST", "/guestbook", nil)
 		require.NoError(t, err)
 
 		rr := httptest.NewRecorder()
 		server.router.ServeHTTP(rr, req)
 
-		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
 	}
 
 	// Next request should be rate limited
-	req, err := http.NewRequest("GET", "/health", nil)
+	req, err := http.NewRequest("POST", "/guestbook", nil)
 	require.NoError(t, err)
 
 	rr := httptest.NewRecorder()
 	server.router.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+	// Health checks are not limited
+	req, err = http.NewRequest("GET", "/health", nil)
+	require.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
 }
 
-// MockGitHubClient implements GitHubClientInterface for testing
-type MockGitHubClient struct {
+// MockEntryStore implements EntryStore for testing
+type MockEntryStore struct {
 	shouldFail bool
+	entries    []*GuestbookEntry
 }
 
-func (m *MockGitHubClient) CreateGuestbookEntry(ctx context.Context, req GuestbookRequest) error {
+func (m *MockEntryStore) CreateEntry(ctx context.Context, entry *GuestbookEntry) error {
 	if m.shouldFail {
 		return assert.AnError
 	}
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *MockEntryStore) GetEntry(ctx context.Context, id string) (*GuestbookEntry, error) {
+	for _, entry := range m.entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, ErrEntryNotFound
+}
+
+func (m *MockEntryStore) ListEntries(ctx context.Context, status EntryStatus) ([]*GuestbookEntry, error) {
+	var entries []*GuestbookEntry
+	for _, entry := range m.entries {
+		if status == "" || entry.Status == status {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *MockEntryStore) UpdateEntryStatus(ctx context.Context, id string, status EntryStatus) error {
+	entry, err := m.GetEntry(ctx, id)
+	if err != nil {
+		return err
+	}
+	entry.Status = status
+	return nil
+}
+
+func (m *MockEntryStore) SetEntryStatus(ctx context.Context, id string, status EntryStatus, reason string) error {
+	entry, err := m.GetEntry(ctx, id)
+	if err != nil {
+		return err
+	}
+	entry.Status = status
+	if reason != "" {
+		entry.Reason = reason
+	}
+	return nil
+}
+
+func (m *MockEntryStore) RecordDecision(ctx context.Context, id string, status EntryStatus, reason string) error {
+	if err := m.SetEntryStatus(ctx, id, status, reason); err != nil {
+		return err
+	}
+	entry, _ := m.GetEntry(ctx, id)
+	entry.Moderated = true
 	return nil
 }
 
-// MockRecaptchaVerifier implements RecaptchaVerifier for testing
+func (m *MockEntryStore) UpdateEntry(ctx context.Context, entry *GuestbookEntry) error {
+	existing, err := m.GetEntry(ctx, entry.ID)
+	if err != nil {
+		return err
+	}
+	existing.Name = entry.Name
+	existing.Message = entry.Message
+	return nil
+}
+
+func (m *MockEntryStore) DeleteEntry(ctx context.Context, id string) error {
+	for i, entry := range m.entries {
+		if entry.ID == id {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			return nil
+		}
+	}
+	return ErrEntryNotFound
+}
+
+// MockRecaptchaVerifier implements CaptchaVerifier for testing
 type MockRecaptchaVerifier struct {
 	shouldVerify bool
+	err          error
 }
 
 func (m *MockRecaptchaVerifier) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
-	return m.shouldVerify, nil
+	return m.shouldVerify, m.err
 }
 
 func TestGuestbookSubmission_Success(t *testing.T) {
@@ -265,9 +347,10 @@ func TestGuestbookSubmission_Success(t *testing.T) {
 
 	server := New(config)
 	// Replace with mock for testing
-	server.github = &MockGitHubClient{shouldFail: false}
+	store := &MockEntryStore{shouldFail: false}
+	server.store = store
 	// Mock reCAPTCHA verification for test
-	server.recaptcha = &MockRecaptchaVerifier{shouldVerify: true}
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
 
 	payload := map[string]string{
 		"name":                 "Test User",
@@ -286,4 +369,94 @@ func TestGuestbookSubmission_Success(t *testing.T) {
 
 	// Should redirect on success
 	assert.Equal(t, http.StatusFound, rr.Code)
+	require.Len(t, store.entries, 1)
+	assert.Equal(t, "Test User", store.entries[0].Name)
+	assert.Equal(t, StatusPending, store.entries[0].Status)
+}
+
+func TestGuestbookSubmission_NoStorageBackend(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// Without a GitHub token there is no store, which must not panic
+	server := New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 100, RateLimitWindow: 60})
+	require.Nil(t, server.store)
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+
+	jsonData, _ := json.Marshal(map[string]string{"name": "Test User", "message": "Hello", "g-recaptcha-response": "mock-response"})
+	req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestGuestbookSubmission_RecordsRejectedEntries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := &Config{
+		Port:              "8080",
+		AllowedOrigins:    []string{"*"},
+		RateLimitRequests: 100,
+		RateLimitWindow:   60,
+	}
+
+	server := New(config)
+	store := &MockEntryStore{}
+	records := &MockEntryStore{}
+	server.store = store
+	server.records = records
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+
+	form := url.Values{}
+	form.Add("name", "Bot")
+	form.Add("message", "Cheap casino loan, buy now")
+	form.Add("g-recaptcha-response", "mock-response")
+
+	req, err := http.NewRequest("POST", "/guestbook", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, store.entries, "spam must not be published")
+	require.Len(t, records.entries, 1)
+	assert.Equal(t, StatusSpam, records.entries[0].Status)
+	assert.Contains(t, records.entries[0].Reason, "casino")
+}
+
+func TestGuestbookSubmission_RecordsFailedPublish(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 100, RateLimitWindow: 60, SpamAcceptThreshold: 0.3})
+	records := &MockEntryStore{}
+	server.store = &MockEntryStore{shouldFail: true}
+	server.records = records
+	server.captcha = &MockRecaptchaVerifier{shouldVerify: true}
+
+	jsonData, _ := json.Marshal(map[string]string{"name": "Test User", "message": "Lovely site", "g-recaptcha-response": "mock-response"})
+	req := httptest.NewRequest("POST", "/guestbook", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	require.Len(t, records.entries, 1)
+	assert.Equal(t, StatusRejected, records.entries[0].Status, "the entry was never published")
+	assert.Contains(t, records.entries[0].Reason, "failed to publish")
+}
+
+func TestVerifyAkismetKey(t *testing.T) {
+	akismet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("invalid"))
+	}))
+	defer akismet.Close()
+
+	server := New(&Config{AllowedOrigins: []string{"*"}, RateLimitRequests: 100, RateLimitWindow: 60})
+	assert.NoError(t, server.verifyAkismetKey(context.Background()), "nothing to verify without Akismet")
+
+	server.akismet = NewAkismetClient("bad-key", "https://example.com", WithBaseURL(akismet.URL))
+	assert.NoError(t, server.verifyAkismetKey(context.Background()), "an invalid key is only logged by default")
+
+	server.config.AkismetRequireValidKey = true
+	assert.ErrorContains(t, server.verifyAkismetKey(context.Background()), "invalid")
 }
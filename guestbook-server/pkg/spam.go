@@ -9,7 +9,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 )
 
 var debugEnabled = os.Getenv("DEBUG") == "true"
@@ -24,26 +26,100 @@ type AkismetClient struct {
 	apiKey  string
 	siteURL string
 	client  *http.Client
+	// endpoint overrides the Akismet REST API base URL; empty means the real API
+	endpoint string
+}
+
+// ClientOption configures the Akismet and captcha clients
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// WithBaseURL sends requests to baseURL instead of the real service, such as
+// a stand-in from the fakeapi package. For Akismet it replaces the REST API
+// base, e.g. "http://localhost:8089/1.1"; for captcha providers it replaces
+// the whole siteverify URL.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(o *clientOptions) { o.baseURL = baseURL }
+}
+
+// WithHTTPClient makes requests with client instead of a default one, e.g.
+// to set a timeout or transport
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(o *clientOptions) { o.httpClient = client }
+}
+
+func newClientOptions(opts []ClientOption) clientOptions {
+	o := clientOptions{httpClient: &http.Client{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.httpClient == nil {
+		o.httpClient = &http.Client{}
+	}
+	return o
 }
 
 type AkismetComment struct {
 	UserIP         string
 	UserAgent      string
 	Referrer       string
+	Permalink      string // Page the comment was posted on
 	CommentType    string
 	CommentAuthor  string
 	CommentContent string
+	CommentDate    time.Time // Sent as comment_date_gmt when set
+	BlogLang       string    // Comma separated language codes, e.g. "en"
+	BlogCharset    string
+	UserRole       string
+	IsTest         bool // Ask Akismet not to learn from this request
+
+	// Name and value of the hidden form field used to catch bots
+	HoneypotFieldName string
+	HoneypotValue     string
 }
 
-func NewAkismetClient(apiKey, siteURL string) *AkismetClient {
+func NewAkismetClient(apiKey, siteURL string, opts ...ClientOption) *AkismetClient {
 	if apiKey == "" {
 		return nil
 	}
+	o := newClientOptions(opts)
 	return &AkismetClient{
-		apiKey:  apiKey,
-		siteURL: siteURL,
-		client:  &http.Client{},
+		apiKey:   apiKey,
+		siteURL:  siteURL,
+		client:   o.httpClient,
+		endpoint: strings.TrimSuffix(o.baseURL, "/"),
+	}
+}
+
+// VerifyKey checks that the API key is valid for the configured site
+func (a *AkismetClient) VerifyKey(ctx context.Context) (bool, error) {
+	if a == nil {
+		return false, fmt.Errorf("akismet client not configured")
+	}
+
+	data := url.Values{}
+	data.Set("key", a.apiKey)
+	data.Set("blog", a.siteURL)
+
+	endpoint := a.endpoint
+	if endpoint == "" {
+		// Key verification is the one method not served from the key's subdomain
+		endpoint = "https://rest.akismet.com/1.1"
+	}
+
+	result, resp, err := a.postTo(ctx, endpoint+"/verify-key", data)
+	if err != nil {
+		return false, err
+	}
+	if result != "valid" {
+		debugLog("Akismet key verification failed: %s %s", result, resp.Header.Get("X-akismet-debug-help"))
+		return false, nil
 	}
+	return true, nil
 }
 
 func (a *AkismetClient) CheckSpam(ctx context.Context, comment AkismetComment) (bool, error) {
@@ -54,6 +130,56 @@ func (a *AkismetClient) CheckSpam(ctx context.Context, comment AkismetComment) (
 
 	debugLog("Starting Akismet spam check for IP: %s, Author: %s", comment.UserIP, comment.CommentAuthor)
 
+	result, resp, err := a.post(ctx, "comment-check", a.commentValues(comment))
+	if err != nil {
+		return false, err
+	}
+
+	isSpam := result == "true"
+	debugLog("Akismet result: %s (isSpam: %t) for author: %s", result, isSpam, comment.CommentAuthor)
+
+	// Check for additional Akismet headers that provide debugging info
+	if debugEnabled {
+		if debugInfo := resp.Header.Get("X-akismet-debug-help"); debugInfo != "" {
+			debugLog("Akismet debug info: %s", debugInfo)
+		}
+		if proTip := resp.Header.Get("X-akismet-pro-tip"); proTip != "" {
+			debugLog("Akismet pro tip: %s", proTip)
+		}
+	}
+
+	return isSpam, nil
+}
+
+// SubmitSpam reports a comment that Akismet missed as spam
+func (a *AkismetClient) SubmitSpam(ctx context.Context, comment AkismetComment) error {
+	return a.submit(ctx, "submit-spam", comment)
+}
+
+// SubmitHam reports a comment that Akismet wrongly flagged as spam
+func (a *AkismetClient) SubmitHam(ctx context.Context, comment AkismetComment) error {
+	return a.submit(ctx, "submit-ham", comment)
+}
+
+func (a *AkismetClient) submit(ctx context.Context, method string, comment AkismetComment) error {
+	if a == nil {
+		debugLog("Akismet client is nil, skipping %s", method)
+		return nil
+	}
+
+	debugLog("Sending Akismet %s for IP: %s, Author: %s", method, comment.UserIP, comment.CommentAuthor)
+
+	result, _, err := a.post(ctx, method, a.commentValues(comment))
+	if err != nil {
+		return err
+	}
+	if result != "Thanks for making the web a better place." {
+		debugLog("Unexpected Akismet %s response: %s", method, result)
+	}
+	return nil
+}
+
+func (a *AkismetClient) commentValues(comment AkismetComment) url.Values {
 	data := url.Values{}
 	data.Set("blog", a.siteURL)
 	data.Set("user_ip", comment.UserIP)
@@ -63,15 +189,48 @@ func (a *AkismetClient) CheckSpam(ctx context.Context, comment AkismetComment) (
 	data.Set("comment_author", comment.CommentAuthor)
 	data.Set("comment_content", comment.CommentContent)
 
+	optional := map[string]string{
+		"permalink":           comment.Permalink,
+		"blog_lang":           comment.BlogLang,
+		"blog_charset":        comment.BlogCharset,
+		"user_role":           comment.UserRole,
+		"honeypot_field_name": comment.HoneypotFieldName,
+	}
+	for key, value := range optional {
+		if value != "" {
+			data.Set(key, value)
+		}
+	}
+	// Akismet reads the honeypot value from a parameter named after the field
+	if comment.HoneypotFieldName != "" && !data.Has(comment.HoneypotFieldName) {
+		data.Set(comment.HoneypotFieldName, comment.HoneypotValue)
+	}
+	if !comment.CommentDate.IsZero() {
+		data.Set("comment_date_gmt", comment.CommentDate.UTC().Format(time.RFC3339))
+	}
+	if comment.IsTest {
+		data.Set("is_test", "1")
+	}
+
 	debugLog("Akismet request data: blog=%s, user_ip=%s, comment_type=%s, content_length=%d",
 		a.siteURL, comment.UserIP, comment.CommentType, len(comment.CommentContent))
+	return data
+}
+
+// post calls an Akismet API method and returns the trimmed response body
+func (a *AkismetClient) post(ctx context.Context, method string, data url.Values) (string, *http.Response, error) {
+	endpoint := a.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.rest.akismet.com/1.1", a.apiKey)
+	}
+	return a.postTo(ctx, endpoint+"/"+method, data)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST",
-		fmt.Sprintf("https://%s.rest.akismet.com/1.1/comment-check", a.apiKey),
-		strings.NewReader(data.Encode()))
+func (a *AkismetClient) postTo(ctx context.Context, apiURL string, data url.Values) (string, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		debugLog("Akismet request creation failed: %v", err)
-		return false, err
+		return "", nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -82,39 +241,28 @@ func (a *AkismetClient) CheckSpam(ctx context.Context, comment AkismetComment) (
 	resp, err := a.client.Do(req)
 	if err != nil {
 		debugLog("Akismet API request failed: %v", err)
-		return false, err
+		return "", nil, err
 	}
 	defer resp.Body.Close()
 
 	debugLog("Akismet API response status: %d", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("akismet API returned status %d", resp.StatusCode)
+		return "", resp, fmt.Errorf("akismet API returned status %d", resp.StatusCode)
 	}
 
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(resp.Body)
-	result := strings.TrimSpace(buf.String())
-
-	isSpam := result == "true"
-	debugLog("Akismet result: %s (isSpam: %t) for author: %s", result, isSpam, comment.CommentAuthor)
-
-	// Check for additional Akismet headers that provide debugging info
-	if debugEnabled {
-		if debugInfo := resp.Header.Get("X-akismet-debug-help"); debugInfo != "" {
-			debugLog("Akismet debug info: %s", debugInfo)
-		}
-		if proTip := resp.Header.Get("X-akismet-pro-tip"); proTip != "" {
-			debugLog("Akismet pro tip: %s", proTip)
-		}
-	}
-
-	return isSpam, nil
+	return strings.TrimSpace(buf.String()), resp, nil
 }
 
 type RecaptchaClient struct {
 	secretKey      string
 	scoreThreshold float64
+	verifyURL      string
+	version        string   // "v3" (score-based, the default) or "v2" (checkbox)
+	actions        []string // Accepted v3 actions
+	policy         tokenPolicy
 	client         *http.Client
 }
 
@@ -127,24 +275,39 @@ type RecaptchaResponse struct {
 	ErrorCodes  []string `json:"error-codes"`
 }
 
-func NewRecaptchaClient(secretKey string, scoreThreshold float64) *RecaptchaClient {
+func NewRecaptchaClient(secretKey string, scoreThreshold float64, opts ...ClientOption) *RecaptchaClient {
 	if secretKey == "" {
 		return nil
 	}
 	if scoreThreshold <= 0 {
 		scoreThreshold = 0.5 // Default threshold
 	}
+	o := newClientOptions(opts)
+	verifyURL := o.baseURL
+	if verifyURL == "" {
+		verifyURL = captchaProviders["recaptcha"].VerifyURL
+	}
 	return &RecaptchaClient{
 		secretKey:      secretKey,
 		scoreThreshold: scoreThreshold,
-		client:         &http.Client{},
+		verifyURL:      verifyURL,
+		version:        "v3",
+		actions:        []string{"submit"},
+		client:         o.httpClient,
 	}
 }
 
 func (r *RecaptchaClient) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	valid, _, err := r.VerifyWithScore(ctx, response, remoteIP)
+	return valid, err
+}
+
+// VerifyWithScore verifies the response like Verify and also returns the
+// reCAPTCHA v3 score, which is 0 when the API did not report one
+func (r *RecaptchaClient) VerifyWithScore(ctx context.Context, response, remoteIP string) (bool, float64, error) {
 	if r == nil {
 		debugLog("reCAPTCHA client is nil, skipping verification")
-		return true, nil // Skip verification if not configured
+		return true, 0, nil // Skip verification if not configured
 	}
 
 	debugLog("Starting reCAPTCHA verification for IP: %s, response length: %d", remoteIP, len(response))
@@ -155,12 +318,10 @@ func (r *RecaptchaClient) Verify(ctx context.Context, response, remoteIP string)
 	data.Set("remoteip", remoteIP)
 	// Don't set action here - let the response tell us what action was used
 
-	req, err := http.NewRequestWithContext(ctx, "POST",
-		"https://www.google.com/recaptcha/api/siteverify",
-		strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", r.verifyURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		debugLog("reCAPTCHA request creation failed: %v", err)
-		return false, err
+		return false, 0, err
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -170,14 +331,14 @@ func (r *RecaptchaClient) Verify(ctx context.Context, response, remoteIP string)
 	resp, err := r.client.Do(req)
 	if err != nil {
 		debugLog("reCAPTCHA API request failed: %v", err)
-		return false, err
+		return false, 0, err
 	}
 	defer resp.Body.Close()
 
 	var result RecaptchaResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		debugLog("Failed to decode reCAPTCHA response: %v", err)
-		return false, err
+		return false, 0, err
 	}
 
 	// Log detailed response for debugging
@@ -189,33 +350,45 @@ func (r *RecaptchaClient) Verify(ctx context.Context, response, remoteIP string)
 			result.Success, result.Score, result.Action, result.Hostname, result.ErrorCodes)
 	}
 
-	// Additional debugging for your specific case
-	if result.Score == 0.0 {
-		if len(result.ErrorCodes) > 0 {
-			debugLog("reCAPTCHA score is 0.0 with errors - this suggests a configuration issue")
-		} else {
-      return false, fmt.Errorf("reCAPTCHA score is 0.0 with no errors - this might be reCAPTCHA v2 on the frontend or a site key mismatch. Considering Spam to be safe.")
+	// Check if basic verification succeeded first. Rejected tokens are the
+	// visitor's fault and are not errors, which would make the failure policy
+	// apply as if reCAPTCHA were down.
+	if !result.Success {
+		debugLog("reCAPTCHA verification failed with errors: %v", result.ErrorCodes)
+		if siteverifyConfigError(result.ErrorCodes) {
+			return false, result.Score, fmt.Errorf("reCAPTCHA verification failed: %v", result.ErrorCodes)
 		}
+		return false, result.Score, nil
 	}
 
-	// Check if basic verification succeeded first
-	if !result.Success {
-		debugLog("reCAPTCHA verification failed with errors: %v", result.ErrorCodes)
-		return false, fmt.Errorf("reCAPTCHA verification failed: %v", result.ErrorCodes)
+	// Reject tokens minted for other sites or replayed later
+	if err := r.policy.check("reCAPTCHA", result.Hostname, result.ChallengeTS); err != nil {
+		debugLog("%v", err)
+		return false, result.Score, nil
+	}
+
+	// v2 checkbox responses have no action or score
+	if r.version == "v2" {
+		debugLog("reCAPTCHA v2 verification successful")
+		return true, 0, nil
+	}
+	if result.Action == "" {
+		log.Printf("reCAPTCHA response has no action, so the token looks like reCAPTCHA v2; set the reCAPTCHA version to v2 to use the checkbox")
+		return false, result.Score, nil
 	}
 
 	// Verify the action name
-	if result.Action != "submit" {
-		debugLog("reCAPTCHA action mismatch: expected 'submit', got '%s'", result.Action)
-		return false, fmt.Errorf("reCAPTCHA action mismatch: expected 'submit', got '%s'", result.Action)
+	if !slices.Contains(r.actions, result.Action) {
+		debugLog("reCAPTCHA action mismatch: expected one of %v, got '%s'", r.actions, result.Action)
+		return false, result.Score, nil
 	}
 
 	// For reCAPTCHA v3, check the score
 	if result.Score < r.scoreThreshold {
 		debugLog("reCAPTCHA score too low: %.2f (minimum: %.2f)", result.Score, r.scoreThreshold)
-		return false, fmt.Errorf("reCAPTCHA score too low: %.2f (minimum: %.2f)", result.Score, r.scoreThreshold)
+		return false, result.Score, nil
 	}
 
 	debugLog("reCAPTCHA verification successful: score=%.2f, threshold=%.2f", result.Score, r.scoreThreshold)
-	return true, nil
+	return true, result.Score, nil
 }
@@ -2,11 +2,15 @@ package guestbook_server
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/bryankaraffa/b10a.co/guestbook-server/pkg/fakeapi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -107,6 +111,100 @@ func TestAkismetClient_CheckSpam(t *testing.T) {
 	})
 }
 
+func TestAkismetClient_VerifyKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "/verify-key", r.URL.Path)
+		assert.Equal(t, "https://example.com", r.Form.Get("blog"))
+		if r.Form.Get("key") == "good-key" {
+			w.Write([]byte("valid"))
+		} else {
+			w.Header().Set("X-akismet-debug-help", "We were unable to parse your blog URI")
+			w.Write([]byte("invalid"))
+		}
+	}))
+	defer server.Close()
+
+	good := NewAkismetClient("good-key", "https://example.com", WithBaseURL(server.URL))
+	valid, err := good.VerifyKey(context.Background())
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	bad := NewAkismetClient("bad-key", "https://example.com", WithBaseURL(server.URL))
+	valid, err = bad.VerifyKey(context.Background())
+	require.NoError(t, err)
+	assert.False(t, valid)
+
+	var nilClient *AkismetClient
+	_, err = nilClient.VerifyKey(context.Background())
+	assert.Error(t, err)
+}
+
+func TestAkismetClient_SendsOptionalFields(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+		w.Write([]byte("false"))
+	}))
+	defer server.Close()
+
+	client := NewAkismetClient("test-key", "https://example.com", WithBaseURL(server.URL))
+
+	_, err := client.CheckSpam(context.Background(), AkismetComment{
+		UserIP:            "127.0.0.1",
+		Permalink:         "https://example.com/guestbook/",
+		CommentAuthor:     "Test User",
+		CommentDate:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		BlogLang:          "en",
+		BlogCharset:       "UTF-8",
+		IsTest:            true,
+		HoneypotFieldName: "website",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://example.com/guestbook/", form.Get("permalink"))
+	assert.Equal(t, "2024-01-02T03:04:05Z", form.Get("comment_date_gmt"))
+	assert.Equal(t, "en", form.Get("blog_lang"))
+	assert.Equal(t, "UTF-8", form.Get("blog_charset"))
+	assert.Equal(t, "1", form.Get("is_test"))
+	assert.Equal(t, "website", form.Get("honeypot_field_name"))
+	assert.True(t, form.Has("website"))
+	assert.False(t, form.Has("user_role"), "empty optional fields are not sent")
+}
+
+func TestAkismetClient_SubmitSpamAndHam(t *testing.T) {
+	// Test with nil client
+	var nilClient *AkismetClient
+	assert.NoError(t, nilClient.SubmitSpam(context.Background(), AkismetComment{}))
+
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "https://example.com", r.Form.Get("blog"))
+		assert.Equal(t, "127.0.0.1", r.Form.Get("user_ip"))
+		assert.Equal(t, "Test User", r.Form.Get("comment_author"))
+		calls = append(calls, r.URL.Path)
+		w.Write([]byte("Thanks for making the web a better place."))
+	}))
+	defer server.Close()
+
+	client := NewAkismetClient("test-key", "https://example.com", WithBaseURL(server.URL+"/1.1"))
+
+	comment := AkismetComment{UserIP: "127.0.0.1", CommentAuthor: "Test User", CommentContent: "Buy now"}
+	require.NoError(t, client.SubmitSpam(context.Background(), comment))
+	require.NoError(t, client.SubmitHam(context.Background(), comment))
+	assert.Equal(t, []string{"/1.1/submit-spam", "/1.1/submit-ham"}, calls)
+
+	// Errors from the API are reported
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	client.endpoint = failing.URL
+	assert.Error(t, client.SubmitSpam(context.Background(), comment))
+}
+
 func TestRecaptchaClient_Verify(t *testing.T) {
 	// Test with nil client (should pass when not configured)
 	var nilClient *RecaptchaClient
@@ -114,44 +212,93 @@ func TestRecaptchaClient_Verify(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, valid)
 
-	// Mock reCAPTCHA server for testing
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, "POST", r.Method)
-		assert.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+	fake := fakeapi.New("example.com")
+	fake.RequireSecret("test-secret")
+	fake.SetCaptcha("low-score-token", fakeapi.CaptchaVerdict{Success: true, Score: 0.3, Action: "submit"})
+	server := httptest.NewServer(fake)
+	defer server.Close()
 
-		err := r.ParseForm()
-		require.NoError(t, err)
+	client := NewRecaptchaClient("test-secret", 0.5, WithBaseURL(server.URL+"/recaptcha/api/siteverify"))
+
+	valid, score, err := client.VerifyWithScore(context.Background(), "valid-token", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, 0.9, score)
 
-		secret := r.Form.Get("secret")
-		response := r.Form.Get("response")
-		remoteip := r.Form.Get("remoteip")
+	valid, score, err = client.VerifyWithScore(context.Background(), "low-score-token", "127.0.0.1")
+	assert.NoError(t, err, "low scores are the visitor's fault, not an outage")
+	assert.False(t, valid)
+	assert.Equal(t, 0.3, score)
 
-		assert.Equal(t, "test-secret", secret)
-		assert.Equal(t, "test-response", response)
-		assert.Equal(t, "127.0.0.1", remoteip)
+	valid, err = client.Verify(context.Background(), "fail", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.False(t, valid)
 
-		// Return success response with high score
-		w.Header().Set("Content-Type", "application/json")
-		if response == "valid-token" {
-			w.Write([]byte(`{"success":true,"score":0.9,"action":"submit","challenge_ts":"2023-01-01T00:00:00Z","hostname":"example.com"}`))
-		} else if response == "low-score-token" {
-			w.Write([]byte(`{"success":true,"score":0.3,"action":"submit","challenge_ts":"2023-01-01T00:00:00Z","hostname":"example.com"}`))
-		} else {
-			w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
-		}
-	}))
+	requests := fake.Requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, "/recaptcha/api/siteverify", requests[0].Path)
+	assert.Equal(t, "test-secret", requests[0].Form.Get("secret"))
+	assert.Equal(t, "valid-token", requests[0].Form.Get("response"))
+	assert.Equal(t, "127.0.0.1", requests[0].Form.Get("remoteip"))
+
+	wrongSecret := NewRecaptchaClient("other-secret", 0.5, WithBaseURL(server.URL+"/recaptcha/api/siteverify"))
+	_, err = wrongSecret.Verify(context.Background(), "valid-token", "127.0.0.1")
+	assert.ErrorContains(t, err, "invalid-input-secret")
+}
+
+func TestClientOptions(t *testing.T) {
+	httpClient := &http.Client{Timeout: 3 * time.Second}
+
+	akismet := NewAkismetClient("test-key", "https://example.com", WithBaseURL("http://localhost:8089/1.1/"), WithHTTPClient(httpClient))
+	assert.Equal(t, "http://localhost:8089/1.1", akismet.endpoint)
+	assert.Same(t, httpClient, akismet.client)
+	assert.Empty(t, NewAkismetClient("test-key", "https://example.com").endpoint, "the real API by default")
+
+	recaptcha := NewRecaptchaClient("test-secret", 0.5, WithBaseURL("http://localhost:8089/siteverify"), WithHTTPClient(httpClient))
+	assert.Equal(t, "http://localhost:8089/siteverify", recaptcha.verifyURL)
+	assert.Same(t, httpClient, recaptcha.client)
+	assert.Equal(t, captchaProviders["recaptcha"].VerifyURL, NewRecaptchaClient("test-secret", 0.5, WithBaseURL("")).verifyURL)
+
+	siteverify := NewSiteverifyClient("turnstile", "test-secret", WithHTTPClient(nil))
+	assert.NotNil(t, siteverify.client)
+	assert.Equal(t, captchaProviders["turnstile"].VerifyURL, siteverify.verifyURL)
+}
+
+func TestAkismetClient_AgainstFake(t *testing.T) {
+	fake := fakeapi.New("example.com")
+	fake.SetAkismetKeys("test-key")
+	fake.SetAkismet("outage", fakeapi.AkismetVerdict{Status: http.StatusInternalServerError})
+	server := httptest.NewServer(fake)
 	defer server.Close()
 
-	// Note: Similar to Akismet, this would require making the URL configurable for testing
-	// The current implementation calls the real Google reCAPTCHA API
-	client := NewRecaptchaClient("test-secret", 0.5)
+	ctx := context.Background()
+	client := NewAkismetClient("test-key", "https://example.com", WithBaseURL(server.URL+"/1.1"))
 
-	// This test would fail with the current implementation
-	_, err = client.Verify(context.Background(), "test-response", "127.0.0.1")
-	// We just test that it doesn't panic since it calls the real API
-	assert.NotPanics(t, func() {
-		client.Verify(context.Background(), "test-response", "127.0.0.1")
-	})
+	valid, err := client.VerifyKey(ctx)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	valid, err = NewAkismetClient("bad-key", "https://example.com", WithBaseURL(server.URL+"/1.1")).VerifyKey(ctx)
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	isSpam, err := client.CheckSpam(ctx, AkismetComment{CommentAuthor: "Joe", CommentContent: "Hello"})
+	assert.NoError(t, err)
+	assert.False(t, isSpam)
+
+	isSpam, err = client.CheckSpam(ctx, AkismetComment{CommentAuthor: fakeapi.SpamAuthor, CommentContent: "Hello"})
+	assert.NoError(t, err)
+	assert.True(t, isSpam)
+
+	_, err = client.CheckSpam(ctx, AkismetComment{CommentAuthor: "Joe", CommentContent: "outage"})
+	assert.ErrorContains(t, err, "status 500")
+
+	assert.NoError(t, client.SubmitHam(ctx, AkismetComment{CommentAuthor: fakeapi.SpamAuthor}))
+
+	requests := fake.Requests()
+	require.Len(t, requests, 6)
+	assert.Equal(t, "/1.1/comment-check", requests[2].Path)
+	assert.Equal(t, "https://example.com", requests[2].Form.Get("blog"))
+	assert.Equal(t, "/1.1/submit-ham", requests[5].Path)
 }
 
 func TestRecaptchaResponse_Scoring(t *testing.T) {
@@ -172,3 +319,82 @@ func TestRecaptchaResponse_Scoring(t *testing.T) {
 	response.Score = 0.3
 	assert.False(t, response.Success && response.Score >= 0.5) // Should fail
 }
+
+func TestRecaptchaClient_ValidatesResponse(t *testing.T) {
+	var response map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newClient := func(config *Config) *RecaptchaClient {
+		config.RecaptchaSecretKey = "test-secret"
+		config.CaptchaVerifyURL = server.URL
+		config.AllowedOrigins = []string{"https://b10a.co"}
+		verifier, _ := newCaptchaVerifier(config)
+		client := verifier.(*RecaptchaClient)
+		client.policy.nowFunc = func() time.Time { return now }
+		return client
+	}
+	v3 := func(hostname, action string, age time.Duration) map[string]interface{} {
+		return map[string]interface{}{
+			"success":      true,
+			"score":        0.9,
+			"action":       action,
+			"hostname":     hostname,
+			"challenge_ts": now.Add(-age).Format(time.RFC3339),
+		}
+	}
+	ctx := context.Background()
+
+	client := newClient(&Config{})
+	response = v3("b10a.co", "submit", 10*time.Second)
+	valid, score, err := client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, 0.9, score)
+
+	response = v3("token-farm.example", "submit", 10*time.Second)
+	valid, _, err = client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.False(t, valid, "hostname mismatch")
+
+	response = v3("b10a.co", "submit", 10*time.Minute)
+	valid, _, err = client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.False(t, valid, "too old")
+
+	response = v3("b10a.co", "login", 10*time.Second)
+	valid, _, err = client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.False(t, valid, "action mismatch")
+
+	client = newClient(&Config{RecaptchaActions: []string{"submit", "guestbook"}, CaptchaMaxTokenAge: 900})
+	response = v3("b10a.co", "guestbook", 10*time.Minute)
+	valid, _, err = client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	// v2 checkbox responses have no score or action
+	v2 := map[string]interface{}{"success": true, "hostname": "b10a.co", "challenge_ts": now.Format(time.RFC3339)}
+	response = v2
+	valid, _, err = client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.False(t, valid, "v2 tokens are rejected by a v3 client")
+
+	client = newClient(&Config{RecaptchaVersion: "v2"})
+	valid, score, err = client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Zero(t, score)
+
+	response = map[string]interface{}{"success": false, "error-codes": []string{"timeout-or-duplicate"}}
+	valid, _, err = client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	response = map[string]interface{}{"success": false, "error-codes": []string{"invalid-input-secret"}}
+	_, _, err = client.VerifyWithScore(ctx, "token", "192.0.2.1")
+	assert.Error(t, err, "configuration faults are errors, so the failure policy applies")
+}
@@ -0,0 +1,241 @@
+package guestbook_server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go driver so the server still builds with CGO_ENABLED=0
+)
+
+// sqliteMigrations are applied in order; PRAGMA user_version records how many have run
+var sqliteMigrations = []string{
+	`CREATE TABLE entries (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		message    TEXT NOT NULL,
+		date       INTEGER NOT NULL,
+		status     TEXT NOT NULL,
+		reason     TEXT NOT NULL DEFAULT '',
+		user_ip    TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		referrer   TEXT NOT NULL DEFAULT '',
+		updated_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX entries_status_date ON entries (status, date)`,
+	`ALTER TABLE entries ADD COLUMN checks TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE entries ADD COLUMN moderated INTEGER NOT NULL DEFAULT 0`,
+	// Decisions made before the column existed, with the default reason
+	`UPDATE entries SET moderated = 1 WHERE reason LIKE '% by moderator'`,
+}
+
+const entryColumns = `id, name, message, date, status, reason, user_ip, user_agent, referrer, checks, moderated`
+
+// SQLiteStore keeps every guestbook submission, including rejected ones, in a
+// SQLite database so moderation decisions can be audited later
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite only allows one writer at a time
+	db.SetMaxOpenConns(1)
+
+	store := &SQLiteStore{db: db}
+	if err := store.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) migrate(ctx context.Context) error {
+	var version int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to start migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept bind parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// CreateEntry inserts the entry. Should another submission have taken its
+// ID, which the random part of IDs makes unlikely, a numeric suffix is added.
+func (s *SQLiteStore) CreateEntry(ctx context.Context, entry *GuestbookEntry) error {
+	if entry.Status == "" {
+		entry.Status = StatusPending
+	}
+
+	checks, err := marshalChecks(entry.Checks)
+	if err != nil {
+		return err
+	}
+
+	baseID := entry.ID
+	for attempt := 2; ; attempt++ {
+		result, err := s.db.ExecContext(ctx,
+			`INSERT INTO entries (`+entryColumns+`, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO NOTHING`,
+			entry.ID, entry.Name, entry.Message, entry.Date, string(entry.Status), entry.Reason,
+			entry.UserIP, entry.UserAgent, entry.Referrer, checks, entry.Moderated, time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("failed to insert entry: %w", err)
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 1 {
+			return err
+		}
+		entry.ID = fmt.Sprintf("%s-%d", baseID, attempt)
+	}
+}
+
+func (s *SQLiteStore) GetEntry(ctx context.Context, id string) (*GuestbookEntry, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+entryColumns+` FROM entries WHERE id = ?`, id)
+	entry, err := scanEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEntryNotFound
+	}
+	return entry, err
+}
+
+func (s *SQLiteStore) ListEntries(ctx context.Context, status EntryStatus) ([]*GuestbookEntry, error) {
+	query := `SELECT ` + entryColumns + ` FROM entries`
+	var args []interface{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, string(status))
+	}
+	query += ` ORDER BY date DESC, id DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*GuestbookEntry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateEntryStatus(ctx context.Context, id string, status EntryStatus) error {
+	return s.SetEntryStatus(ctx, id, status, "")
+}
+
+// SetEntryStatus changes the status of an entry together with the reason for
+// the change. An empty reason keeps the previous one.
+func (s *SQLiteStore) SetEntryStatus(ctx context.Context, id string, status EntryStatus, reason string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE entries SET status = ?, reason = CASE WHEN ? = '' THEN reason ELSE ? END, updated_at = ? WHERE id = ?`,
+		string(status), reason, reason, time.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update entry status: %w", err)
+	}
+	return requireOneRow(result)
+}
+
+// RecordDecision sets the status a moderator chose, with the reason, and marks
+// the entry as moderated
+func (s *SQLiteStore) RecordDecision(ctx context.Context, id string, status EntryStatus, reason string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE entries SET status = ?, reason = CASE WHEN ? = '' THEN reason ELSE ? END, moderated = 1, updated_at = ? WHERE id = ?`,
+		string(status), reason, reason, time.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to record moderation decision: %w", err)
+	}
+	return requireOneRow(result)
+}
+
+// UpdateEntry saves the name and message of an existing entry
+func (s *SQLiteStore) UpdateEntry(ctx context.Context, entry *GuestbookEntry) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE entries SET name = ?, message = ?, updated_at = ? WHERE id = ?`,
+		entry.Name, entry.Message, time.Now().Unix(), entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update entry: %w", err)
+	}
+	return requireOneRow(result)
+}
+
+func (s *SQLiteStore) DeleteEntry(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM entries WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete entry: %w", err)
+	}
+	return requireOneRow(result)
+}
+
+func requireOneRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEntryNotFound
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEntry(row rowScanner) (*GuestbookEntry, error) {
+	var entry GuestbookEntry
+	var status, checks string
+	err := row.Scan(&entry.ID, &entry.Name, &entry.Message, &entry.Date, &status, &entry.Reason,
+		&entry.UserIP, &entry.UserAgent, &entry.Referrer, &checks, &entry.Moderated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entry: %w", err)
+	}
+	entry.Status = EntryStatus(status)
+	if err := json.Unmarshal([]byte(checks), &entry.Checks); err != nil {
+		return nil, fmt.Errorf("failed to decode spam checks of entry %s: %w", entry.ID, err)
+	}
+	return &entry, nil
+}
+
+func marshalChecks(checks []SpamCheckResult) (string, error) {
+	if checks == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(checks)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode spam checks: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,113 @@
+package guestbook_server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "guestbook.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteStore_Lifecycle(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	entry := &GuestbookEntry{
+		ID:        "20240101120000",
+		Name:      "John Doe",
+		Message:   "Hello!",
+		Date:      1704110400,
+		Reason:    "passed all spam checks",
+		UserIP:    "192.0.2.1",
+		UserAgent: "Mozilla/5.0",
+		Checks: []SpamCheckResult{
+			{Check: "recaptcha", Verdict: VerdictPass},
+			{Check: "akismet", Verdict: VerdictError, Details: "akismet API returned status 500"},
+		},
+	}
+	require.NoError(t, store.CreateEntry(ctx, entry))
+	assert.Equal(t, StatusPending, entry.Status)
+
+	got, err := store.GetEntry(ctx, entry.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entry, got)
+
+	require.NoError(t, store.SetEntryStatus(ctx, entry.ID, StatusSpam, "marked as spam by moderator"))
+	got, err = store.GetEntry(ctx, entry.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSpam, got.Status)
+	assert.Equal(t, "marked as spam by moderator", got.Reason)
+
+	// UpdateEntryStatus keeps the previous reason
+	require.NoError(t, store.UpdateEntryStatus(ctx, entry.ID, StatusRejected))
+	got, err = store.GetEntry(ctx, entry.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRejected, got.Status)
+	assert.Equal(t, "marked as spam by moderator", got.Reason)
+
+	require.NoError(t, store.DeleteEntry(ctx, entry.ID))
+	_, err = store.GetEntry(ctx, entry.ID)
+	assert.ErrorIs(t, err, ErrEntryNotFound)
+	assert.ErrorIs(t, store.DeleteEntry(ctx, entry.ID), ErrEntryNotFound)
+	assert.ErrorIs(t, store.UpdateEntryStatus(ctx, entry.ID, StatusApproved), ErrEntryNotFound)
+}
+
+func TestSQLiteStore_DuplicateIDs(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	first := &GuestbookEntry{ID: "20240101120000", Name: "First", Date: 1704110400}
+	second := &GuestbookEntry{ID: "20240101120000", Name: "Second", Date: 1704110400}
+	third := &GuestbookEntry{ID: "20240101120000", Name: "Third", Date: 1704110400}
+	require.NoError(t, store.CreateEntry(ctx, first))
+	require.NoError(t, store.CreateEntry(ctx, second))
+	require.NoError(t, store.CreateEntry(ctx, third))
+
+	assert.Equal(t, "20240101120000", first.ID)
+	assert.Equal(t, "20240101120000-2", second.ID)
+	assert.Equal(t, "20240101120000-3", third.ID)
+}
+
+func TestSQLiteStore_ListEntries(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.CreateEntry(ctx, &GuestbookEntry{ID: "a", Date: 1, Status: StatusSpam}))
+	require.NoError(t, store.CreateEntry(ctx, &GuestbookEntry{ID: "b", Date: 2, Status: StatusPending}))
+	require.NoError(t, store.CreateEntry(ctx, &GuestbookEntry{ID: "c", Date: 3, Status: StatusSpam}))
+
+	spam, err := store.ListEntries(ctx, StatusSpam)
+	require.NoError(t, err)
+	require.Len(t, spam, 2)
+	assert.Equal(t, "c", spam[0].ID, "newest entries come first")
+	assert.Equal(t, "a", spam[1].ID)
+
+	all, err := store.ListEntries(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+func TestSQLiteStore_ReopenKeepsData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guestbook.db")
+	store, err := NewSQLiteStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateEntry(context.Background(), &GuestbookEntry{ID: "a", Name: "Kept"}))
+	require.NoError(t, store.Close())
+
+	store, err = NewSQLiteStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	entry, err := store.GetEntry(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Kept", entry.Name)
+}
@@ -0,0 +1,84 @@
+package guestbook_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// EntryStatus is the moderation state of a guestbook entry
+type EntryStatus string
+
+const (
+	StatusPending  EntryStatus = "pending"
+	StatusApproved EntryStatus = "approved"
+	StatusRejected EntryStatus = "rejected"
+	StatusSpam     EntryStatus = "spam"
+)
+
+// entryStatuses lists every known status in the order stores search them
+var entryStatuses = []EntryStatus{StatusPending, StatusApproved, StatusRejected, StatusSpam}
+
+// ErrEntryNotFound is returned by an EntryStore when no entry has the requested ID
+var ErrEntryNotFound = errors.New("guestbook entry not found")
+
+// EntryStore persists guestbook entries and their moderation state
+type EntryStore interface {
+	CreateEntry(ctx context.Context, entry *GuestbookEntry) error
+	GetEntry(ctx context.Context, id string) (*GuestbookEntry, error)
+	// ListEntries returns entries with the given status, or all entries if status is empty
+	ListEntries(ctx context.Context, status EntryStatus) ([]*GuestbookEntry, error)
+	UpdateEntryStatus(ctx context.Context, id string, status EntryStatus) error
+	DeleteEntry(ctx context.Context, id string) error
+}
+
+// EntryEditor is implemented by stores that can change the content of an entry
+type EntryEditor interface {
+	UpdateEntry(ctx context.Context, entry *GuestbookEntry) error
+}
+
+// ModerationStore is an EntryStore that also keeps the moderation context of
+// each submission, such as why it was given its status
+type ModerationStore interface {
+	EntryStore
+	EntryEditor
+	SetEntryStatus(ctx context.Context, id string, status EntryStatus, reason string) error
+	// RecordDecision is SetEntryStatus for a status a moderator chose, and
+	// marks the entry as moderated
+	RecordDecision(ctx context.Context, id string, status EntryStatus, reason string) error
+}
+
+// ParseEntryStatus converts a string into an EntryStatus, rejecting unknown values
+func ParseEntryStatus(s string) (EntryStatus, error) {
+	for _, status := range entryStatuses {
+		if string(status) == s {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("unknown entry status %q", s)
+}
+
+// newEntryStore creates the EntryStore selected by config.StorageBackend
+func newEntryStore(config *Config) (EntryStore, error) {
+	switch config.StorageBackend {
+	case "", "github":
+		// NewGitHubClient returns a nil *GitHubClient without a token, which
+		// would not compare equal to nil once wrapped in an EntryStore
+		if config.GitHubToken == "" {
+			return nil, fmt.Errorf("github storage backend requires GitHubToken")
+		}
+		mode, err := ParseGitHubPublishMode(config.GitHubPublishMode)
+		if err != nil {
+			return nil, err
+		}
+		return NewGitHubClient(config.GitHubToken, config.GitHubOwner, config.GitHubRepo, config.GitHubBranch,
+			WithPublishMode(mode, config.GitHubPendingBranch)), nil
+	case "file":
+		if config.FileStorePath == "" {
+			return nil, fmt.Errorf("file storage backend requires FileStorePath")
+		}
+		return NewFileStore(config.FileStorePath), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.StorageBackend)
+	}
+}
@@ -0,0 +1,37 @@
+package guestbook_server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEntryStore(t *testing.T) {
+	store, err := newEntryStore(&Config{StorageBackend: "file", FileStorePath: t.TempDir()})
+	require.NoError(t, err)
+	assert.IsType(t, &FileStore{}, store)
+
+	store, err = newEntryStore(&Config{GitHubToken: "test-token"})
+	require.NoError(t, err)
+	assert.IsType(t, &GitHubClient{}, store)
+
+	_, err = newEntryStore(&Config{StorageBackend: "file"})
+	assert.Error(t, err)
+
+	store, err = newEntryStore(&Config{})
+	assert.ErrorContains(t, err, "GitHubToken")
+	assert.Nil(t, store, "the store must be a nil interface so s.store == nil guards work")
+
+	_, err = newEntryStore(&Config{StorageBackend: "s3"})
+	assert.Error(t, err)
+}
+
+func TestParseEntryStatus(t *testing.T) {
+	status, err := ParseEntryStatus("spam")
+	require.NoError(t, err)
+	assert.Equal(t, StatusSpam, status)
+
+	_, err = ParseEntryStatus("deleted")
+	assert.Error(t, err)
+}
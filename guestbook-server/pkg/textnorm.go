@@ -0,0 +1,108 @@
+package guestbook_server
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables folds characters that look like Latin letters onto them, so
+// "саsinо" written with Cyrillic letters still matches "casino". It covers
+// the Cyrillic and Greek lookalikes seen in spam rather than the full Unicode
+// confusables table.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'ё': 'e', 'һ': 'h',
+	'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'у': 'y', 'ԝ': 'w', 'х': 'x',
+	'ү': 'y', 'ɡ': 'g',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ϲ': 'c',
+}
+
+// normalizeText prepares text for spam matching: NFKC normalization (which
+// also maps fullwidth and stylised letters such as "𝐜𝐚𝐬𝐢𝐧𝐨" to ASCII),
+// case folding, stripping invisible format characters such as zero-width
+// spaces and soft hyphens, and folding confusable letters
+func normalizeText(s string) string {
+	// Casers are stateful, so each call gets its own
+	s = cases.Fold().String(norm.NFKC.String(s))
+	return strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		if latin, ok := confusables[r]; ok {
+			return latin
+		}
+		return r
+	}, s)
+}
+
+// wordTokens splits normalized text into words of letters and digits
+func wordTokens(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.Is(unicode.Mn, r)
+	})
+}
+
+// keywordMatcher finds keywords in text on word boundaries, so "free" does
+// not match "freedom" and "loan" does not match "Sloane". Keywords may span
+// several words, such as "click here", and their last word also matches its
+// plural ("deals", "casinos").
+type keywordMatcher struct {
+	keywords []string
+	tokens   [][]string
+}
+
+func newKeywordMatcher(keywords []string) *keywordMatcher {
+	m := &keywordMatcher{}
+	for _, keyword := range keywords {
+		tokens := wordTokens(normalizeText(keyword))
+		if len(tokens) == 0 {
+			continue
+		}
+		m.keywords = append(m.keywords, strings.Join(tokens, " "))
+		m.tokens = append(m.tokens, tokens)
+	}
+	return m
+}
+
+// Match returns the keywords found in text, in keyword order
+func (m *keywordMatcher) Match(text string) []string {
+	words := wordTokens(normalizeText(text))
+	var found []string
+	for i, keyword := range m.tokens {
+		if containsWords(words, keyword) {
+			found = append(found, m.keywords[i])
+		}
+	}
+	return found
+}
+
+// containsWords reports whether words contains the sequence seq
+func containsWords(words, seq []string) bool {
+	last := len(seq) - 1
+	for i := 0; i+len(seq) <= len(words); i++ {
+		match := true
+		for j := range seq {
+			word := words[i+j]
+			if word != seq[j] && (j != last || !isPlural(word, seq[j])) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlural reports whether word is a regular English plural of singular
+func isPlural(word, singular string) bool {
+	rest, ok := strings.CutPrefix(word, singular)
+	return ok && (rest == "s" || rest == "es")
+}
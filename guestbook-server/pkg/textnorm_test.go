@@ -0,0 +1,82 @@
+package guestbook_server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNormalizeText(t *testing.T) {
+	tests := map[string]string{
+		"Hello World":        "hello world",
+		"c\u200basino":       "casino", // zero-width space
+		"l\u00adoan":         "loan",   // soft hyphen
+		"vi\u200c\u200dagra": "viagra", // zero-width (non-)joiners
+		"\ufeffbitcoin":      "bitcoin",
+		"саsinо":             "casino", // Cyrillic а and о
+		"ΒΙΤcοin":            "bitcoin",
+		"ＦＲＥＥ":               "free", // fullwidth
+		"𝐁𝐮𝐲 𝐧𝐨𝐰":            "buy now",
+		"Straße":             "strasse",
+	}
+	for input, expected := range tests {
+		assert.Equal(t, expected, normalizeText(input), "normalizeText(%q)", input)
+	}
+}
+
+func TestKeywordMatcher_WordBoundaries(t *testing.T) {
+	matcher := newKeywordMatcher([]string{"free", "loan", "click here", "deal"})
+
+	assert.Equal(t, []string{"free"}, matcher.Match("Feel free to reach out!"))
+	assert.Equal(t, []string{"deal"}, matcher.Match("Great deals today"), "plurals match")
+	assert.Equal(t, []string{"click here"}, matcher.Match("CLICK   here: http://spam.example"))
+
+	for _, text := range []string{
+		"Freedom is a wonderful thing",
+		"Greetings from Sloane Square",
+		"I was dealt a good hand",
+		"Thanks for clicking, here is my reply",
+	} {
+		assert.Empty(t, matcher.Match(text), text)
+	}
+}
+
+// Spam samples that hide keywords with Unicode tricks
+func TestKeywordMatcher_SpamSamples(t *testing.T) {
+	matcher := newKeywordMatcher(defaultSpamRules.Keywords)
+
+	samples := map[string][]string{
+		"Best online с\u200bаѕіnо bonus, no deposit!":         {"casino"},
+		"𝐁𝐮𝐲 𝐧𝐨𝐰 cheap pills, vi\u00adagra shipped worldwide": {"buy now", "viagra"},
+		"Earn ＢＩＴＣＯＩＮ daily – CLICK\u200b HERE":               {"click here", "bitcoin"},
+		"Fast payday l\u200dоans approved in minutes":         {"loan"},
+	}
+	for sample, expected := range samples {
+		assert.Equal(t, expected, matcher.Match(sample), sample)
+	}
+}
+
+// The published guestbook entries must not trip the keyword heuristics
+func TestSpamSignals_PublishedEntries(t *testing.T) {
+	files, err := filepath.Glob("../../data/guestbook/*.yml")
+	require.NoError(t, err)
+	if len(files) == 0 {
+		t.Skip("no published guestbook entries found")
+	}
+
+	server := &Server{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		var entry GuestbookEntry
+		require.NoError(t, yaml.Unmarshal(data, &entry), file)
+
+		for _, signal := range server.spamSignals(GuestbookRequest{Name: entry.Name, Message: entry.Message}) {
+			assert.NotEqual(t, "keyword", signal.Name, "%s: %s", file, signal.Detail)
+		}
+	}
+}
@@ -1,23 +1,70 @@
 package guestbook_server
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"html/template"
 	"time"
 )
 
 type GuestbookRequest struct {
-	Name              string `form:"name" json:"name" binding:"required"`
-	Message           string `form:"message" json:"message"`
-	RecaptchaResponse string `form:"g-recaptcha-response" json:"g-recaptcha-response"`
-	Redirect          string `form:"redirect" json:"redirect"`
-	Honeypot          string `form:"website" json:"website"` // Honeypot field
+	Name            string `form:"name" json:"name" binding:"required"`
+	Message         string `form:"message" json:"message"`
+	CaptchaResponse string `form:"g-recaptcha-response" json:"g-recaptcha-response"` // See Server.captchaField
+	Redirect        string `form:"redirect" json:"redirect"`
+	Honeypot        string `form:"website" json:"website"`       // Honeypot field
+	FormToken       string `form:"form-token" json:"form-token"` // See FormTokens
+	FormPage        string `form:"form-page" json:"form-page"`   // Path of the page the form is on
 }
 
 type GuestbookEntry struct {
-	ID      string `yaml:"_id"`
-	Name    string `yaml:"name"`
-	Message string `yaml:"message"`
-	Date    int64  `yaml:"date"`
+	ID      string `yaml:"_id" json:"id"`
+	Name    string `yaml:"name" json:"name"`
+	Message string `yaml:"message" json:"message"`
+	Date    int64  `yaml:"date" json:"date"`
+
+	// Moderation metadata, tracked by the store and never written to the Hugo data file
+	Status    EntryStatus       `yaml:"-" json:"status"`
+	Reason    string            `yaml:"-" json:"reason,omitempty"`    // Why the entry was given its status
+	Moderated bool              `yaml:"-" json:"moderated,omitempty"` // Whether a moderator set the status
+	UserIP    string            `yaml:"-" json:"user_ip,omitempty"`
+	UserAgent string            `yaml:"-" json:"user_agent,omitempty"`
+	Referrer  string            `yaml:"-" json:"referrer,omitempty"`
+	Checks    []SpamCheckResult `yaml:"-" json:"checks,omitempty"`
+}
+
+// SpamCheckResult is the verdict of one spam check on a submission
+type SpamCheckResult struct {
+	Check   string   `json:"check"`   // honeypot, recaptcha, akismet or heuristics
+	Verdict string   `json:"verdict"` // pass, spam, error or skipped
+	Details string   `json:"details,omitempty"`
+	Score   *float64 `json:"score,omitempty"` // Set by checks that report a score, such as reCAPTCHA v3
+	// SpamScore is the weighted evidence the check added to the spam score
+	SpamScore float64 `json:"spam_score,omitempty"`
+	// Policy is the failure policy applied when the check errored
+	Policy FailurePolicy `json:"policy,omitempty"`
+	// Shadow checks are recorded for evaluation but never reject submissions
+	Shadow bool `json:"shadow,omitempty"`
+}
+
+// Spam check verdicts
+const (
+	VerdictPass    = "pass"
+	VerdictSpam    = "spam"
+	VerdictReview  = "review" // Needs a human to look, without evidence of spam
+	VerdictError   = "error"
+	VerdictSkipped = "skipped"
+)
+
+// Check returns the result of the named spam check, or nil if it did not run
+func (e *GuestbookEntry) Check(check string) *SpamCheckResult {
+	for i := range e.Checks {
+		if e.Checks[i].Check == check {
+			return &e.Checks[i]
+		}
+	}
+	return nil
 }
 
 func (r *GuestbookRequest) ToEntry() *GuestbookEntry {
@@ -26,6 +73,7 @@ func (r *GuestbookRequest) ToEntry() *GuestbookEntry {
 		Name:    sanitizeString(r.Name),
 		Message: sanitizeString(r.Message),
 		Date:    time.Now().Unix(),
+		Status:  StatusPending,
 	}
 }
 
@@ -34,6 +82,19 @@ func sanitizeString(s string) string {
 	return template.HTMLEscapeString(s)
 }
 
+// generateID returns a sortable, unique entry ID: the submission time plus a
+// random suffix, so entries arriving in the same second do not collide
 func generateID() string {
-	return time.Now().Format("20060102150405")
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// entryFileName names the YAML file of an entry. Entries without an ID, such
+// as those written before IDs were unique, are named after their date.
+func entryFileName(entry *GuestbookEntry) string {
+	if entry.ID == "" {
+		return fmt.Sprintf("entry%d.yml", entry.Date)
+	}
+	return fmt.Sprintf("entry%s.yml", entry.ID)
 }
@@ -55,11 +55,12 @@ func TestSanitizeString(t *testing.T) {
 
 func TestGenerateID(t *testing.T) {
 	id1 := generateID()
-	time.Sleep(time.Second) // Ensure different second timestamps
 	id2 := generateID()
 
 	assert.NotEmpty(t, id1)
-	assert.NotEmpty(t, id2)
-	assert.NotEqual(t, id1, id2)
-	assert.Len(t, id1, 14) // YYYYMMDDHHMMSS = 14 characters
+	assert.NotEqual(t, id1, id2, "IDs generated in the same second differ")
+	assert.Regexp(t, `^\d{14}-[0-9a-f]{8}$`, id1) // YYYYMMDDHHMMSS-random
+
+	assert.Equal(t, "entry"+id1+".yml", entryFileName(&GuestbookEntry{ID: id1, Date: 1704110400}))
+	assert.Equal(t, "entry1704110400.yml", entryFileName(&GuestbookEntry{Date: 1704110400}))
 }